@@ -29,6 +29,10 @@ type Bar struct {
 	Image         string            `protobuf:"bytes,2,opt,name=image,proto3" json:"image,omitempty"`
 	ContainerName string            `protobuf:"bytes,3,opt,name=container_name,json=containerName,proto3" json:"container_name,omitempty"`
 	Annotations   map[string]string `protobuf:"bytes,4,rep,name=annotations,proto3" json:"annotations,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	AutoRollback  *AutoRollback     `protobuf:"bytes,5,opt,name=auto_rollback,json=autoRollback,proto3" json:"auto_rollback,omitempty"`
+	// deletion_policy is one of Delete, Orphan or Retain and decides what
+	// happens to the children of the Bar when it is deleted. Defaults to Delete.
+	DeletionPolicy string `protobuf:"bytes,6,opt,name=deletion_policy,json=deletionPolicy,proto3" json:"deletion_policy,omitempty"`
 }
 
 func (x *Bar) Reset() {
@@ -91,12 +95,85 @@ func (x *Bar) GetAnnotations() map[string]string {
 	return nil
 }
 
+func (x *Bar) GetAutoRollback() *AutoRollback {
+	if x != nil {
+		return x.AutoRollback
+	}
+	return nil
+}
+
+func (x *Bar) GetDeletionPolicy() string {
+	if x != nil {
+		return x.DeletionPolicy
+	}
+	return ""
+}
+
+// AutoRollback reverts the Deployment to the last fully ready image when a
+// rollout of a new image fails.
+type AutoRollback struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Enabled bool `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	// crash_loop_threshold is the number of restarts of a container of the new
+	// image after which the rollout is considered failed. 0 disables crash loop detection.
+	CrashLoopThreshold int32 `protobuf:"varint,2,opt,name=crash_loop_threshold,json=crashLoopThreshold,proto3" json:"crash_loop_threshold,omitempty"`
+}
+
+func (x *AutoRollback) Reset() {
+	*x = AutoRollback{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_foo_v1alpha1_bar_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AutoRollback) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AutoRollback) ProtoMessage() {}
+
+func (x *AutoRollback) ProtoReflect() protoreflect.Message {
+	mi := &file_api_foo_v1alpha1_bar_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AutoRollback.ProtoReflect.Descriptor instead.
+func (*AutoRollback) Descriptor() ([]byte, []int) {
+	return file_api_foo_v1alpha1_bar_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AutoRollback) GetEnabled() bool {
+	if x != nil {
+		return x.Enabled
+	}
+	return false
+}
+
+func (x *AutoRollback) GetCrashLoopThreshold() int32 {
+	if x != nil {
+		return x.CrashLoopThreshold
+	}
+	return 0
+}
+
 var File_api_foo_v1alpha1_bar_proto protoreflect.FileDescriptor
 
 var file_api_foo_v1alpha1_bar_proto_rawDesc = []byte{
 	0x0a, 0x1a, 0x61, 0x70, 0x69, 0x2f, 0x66, 0x6f, 0x6f, 0x2f, 0x76, 0x31, 0x61, 0x6c, 0x70, 0x68,
 	0x61, 0x31, 0x2f, 0x62, 0x61, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0c, 0x66, 0x6f,
-	0x6f, 0x2e, 0x76, 0x31, 0x61, 0x6c, 0x70, 0x68, 0x61, 0x31, 0x22, 0xe4, 0x01, 0x0a, 0x03, 0x42,
+	0x6f, 0x2e, 0x76, 0x31, 0x61, 0x6c, 0x70, 0x68, 0x61, 0x31, 0x22, 0xce, 0x02, 0x0a, 0x03, 0x42,
 	0x61, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x73, 0x18, 0x01,
 	0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x72, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x73, 0x12, 0x14,
 	0x0a, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x69,
@@ -107,15 +184,27 @@ var file_api_foo_v1alpha1_bar_proto_rawDesc = []byte{
 	0x32, 0x22, 0x2e, 0x66, 0x6f, 0x6f, 0x2e, 0x76, 0x31, 0x61, 0x6c, 0x70, 0x68, 0x61, 0x31, 0x2e,
 	0x42, 0x61, 0x72, 0x2e, 0x41, 0x6e, 0x6e, 0x6f, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x45,
 	0x6e, 0x74, 0x72, 0x79, 0x52, 0x0b, 0x61, 0x6e, 0x6e, 0x6f, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e,
-	0x73, 0x1a, 0x3e, 0x0a, 0x10, 0x41, 0x6e, 0x6e, 0x6f, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73,
-	0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38,
-	0x01, 0x42, 0x36, 0x5a, 0x34, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
-	0x76, 0x69, 0x65, 0x74, 0x61, 0x6e, 0x68, 0x64, 0x75, 0x6f, 0x6e, 0x67, 0x2f, 0x78, 0x63, 0x6f,
-	0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x6c, 0x65, 0x72, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x66, 0x6f, 0x6f,
-	0x2f, 0x76, 0x31, 0x61, 0x6c, 0x70, 0x68, 0x61, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x33,
+	0x73, 0x12, 0x3f, 0x0a, 0x0d, 0x61, 0x75, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x6c, 0x6c, 0x62, 0x61,
+	0x63, 0x6b, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x66, 0x6f, 0x6f, 0x2e, 0x76,
+	0x31, 0x61, 0x6c, 0x70, 0x68, 0x61, 0x31, 0x2e, 0x41, 0x75, 0x74, 0x6f, 0x52, 0x6f, 0x6c, 0x6c,
+	0x62, 0x61, 0x63, 0x6b, 0x52, 0x0c, 0x61, 0x75, 0x74, 0x6f, 0x52, 0x6f, 0x6c, 0x6c, 0x62, 0x61,
+	0x63, 0x6b, 0x12, 0x27, 0x0a, 0x0f, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x70,
+	0x6f, 0x6c, 0x69, 0x63, 0x79, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x64, 0x65, 0x6c,
+	0x65, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x1a, 0x3e, 0x0a, 0x10, 0x41,
+	0x6e, 0x6e, 0x6f, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12,
+	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65,
+	0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x5a, 0x0a, 0x0c, 0x41,
+	0x75, 0x74, 0x6f, 0x52, 0x6f, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x12, 0x18, 0x0a, 0x07, 0x65,
+	0x6e, 0x61, 0x62, 0x6c, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x65, 0x6e,
+	0x61, 0x62, 0x6c, 0x65, 0x64, 0x12, 0x30, 0x0a, 0x14, 0x63, 0x72, 0x61, 0x73, 0x68, 0x5f, 0x6c,
+	0x6f, 0x6f, 0x70, 0x5f, 0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x12, 0x63, 0x72, 0x61, 0x73, 0x68, 0x4c, 0x6f, 0x6f, 0x70, 0x54, 0x68,
+	0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x42, 0x36, 0x5a, 0x34, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x76, 0x69, 0x65, 0x74, 0x61, 0x6e, 0x68, 0x64, 0x75, 0x6f,
+	0x6e, 0x67, 0x2f, 0x78, 0x63, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x6c, 0x65, 0x72, 0x2f, 0x61,
+	0x70, 0x69, 0x2f, 0x66, 0x6f, 0x6f, 0x2f, 0x76, 0x31, 0x61, 0x6c, 0x70, 0x68, 0x61, 0x31, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -130,18 +219,20 @@ func file_api_foo_v1alpha1_bar_proto_rawDescGZIP() []byte {
 	return file_api_foo_v1alpha1_bar_proto_rawDescData
 }
 
-var file_api_foo_v1alpha1_bar_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_api_foo_v1alpha1_bar_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_api_foo_v1alpha1_bar_proto_goTypes = []interface{}{
-	(*Bar)(nil), // 0: foo.v1alpha1.Bar
-	nil,         // 1: foo.v1alpha1.Bar.AnnotationsEntry
+	(*Bar)(nil),          // 0: foo.v1alpha1.Bar
+	(*AutoRollback)(nil), // 1: foo.v1alpha1.AutoRollback
+	nil,                  // 2: foo.v1alpha1.Bar.AnnotationsEntry
 }
 var file_api_foo_v1alpha1_bar_proto_depIdxs = []int32{
-	1, // 0: foo.v1alpha1.Bar.annotations:type_name -> foo.v1alpha1.Bar.AnnotationsEntry
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	2, // 0: foo.v1alpha1.Bar.annotations:type_name -> foo.v1alpha1.Bar.AnnotationsEntry
+	1, // 1: foo.v1alpha1.Bar.auto_rollback:type_name -> foo.v1alpha1.AutoRollback
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_api_foo_v1alpha1_bar_proto_init() }
@@ -162,6 +253,18 @@ func file_api_foo_v1alpha1_bar_proto_init() {
 				return nil
 			}
 		}
+		file_api_foo_v1alpha1_bar_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AutoRollback); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -169,7 +272,7 @@ func file_api_foo_v1alpha1_bar_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_api_foo_v1alpha1_bar_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -25,3 +25,24 @@ func (in *Bar) DeepCopy() *Bar {
 func (in *Bar) DeepCopyInterface() interface{} {
 	return in.DeepCopy()
 }
+
+// DeepCopyInto supports using AutoRollback within kubernetes types, where deepcopy-gen is used.
+func (in *AutoRollback) DeepCopyInto(out *AutoRollback) {
+	p := proto.Clone(in).(*AutoRollback)
+	*out = *p
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoRollback. Required by controller-gen.
+func (in *AutoRollback) DeepCopy() *AutoRollback {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoRollback)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInterface is an autogenerated deepcopy function, copying the receiver, creating a new AutoRollback. Required by controller-gen.
+func (in *AutoRollback) DeepCopyInterface() interface{} {
+	return in.DeepCopy()
+}
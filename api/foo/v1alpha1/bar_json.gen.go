@@ -17,6 +17,17 @@ func (this *Bar) UnmarshalJSON(b []byte) error {
 	return BarUnmarshaler.Unmarshal(bytes.NewReader(b), this)
 }
 
+// MarshalJSON is a custom marshaler for AutoRollback
+func (this *AutoRollback) MarshalJSON() ([]byte, error) {
+	str, err := BarMarshaler.MarshalToString(this)
+	return []byte(str), err
+}
+
+// UnmarshalJSON is a custom unmarshaler for AutoRollback
+func (this *AutoRollback) UnmarshalJSON(b []byte) error {
+	return BarUnmarshaler.Unmarshal(bytes.NewReader(b), this)
+}
+
 var (
 	BarMarshaler   = &jsonpb.Marshaler{}
 	BarUnmarshaler = &jsonpb.Unmarshaler{AllowUnknownFields: true}
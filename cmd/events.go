@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+const (
+	// eventFlushTimeout bounds the time spent writing the pending Events on
+	// exit.
+	eventFlushTimeout = 10 * time.Second
+	// eventFlushPollInterval is the interval the sink is checked for pending
+	// Events at.
+	eventFlushPollInterval = 100 * time.Millisecond
+)
+
+// eventSink counts the Event writes in flight, so the pending Events can be
+// flushed on exit.
+type eventSink struct {
+	record.EventSink
+	inflight int32
+}
+
+func (s *eventSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	atomic.AddInt32(&s.inflight, 1)
+	defer atomic.AddInt32(&s.inflight, -1)
+	return s.EventSink.Create(event)
+}
+
+func (s *eventSink) Update(event *corev1.Event) (*corev1.Event, error) {
+	atomic.AddInt32(&s.inflight, 1)
+	defer atomic.AddInt32(&s.inflight, -1)
+	return s.EventSink.Update(event)
+}
+
+func (s *eventSink) Patch(event *corev1.Event, data []byte) (*corev1.Event, error) {
+	atomic.AddInt32(&s.inflight, 1)
+	defer atomic.AddInt32(&s.inflight, -1)
+	return s.EventSink.Patch(event, data)
+}
+
+// shutdownEvents shuts the broadcaster down and waits up to timeout for the
+// sink watcher to write the Events recorded so far. Shutdown hands the queued
+// Events over to the watchers but does not wait for them to be written.
+func shutdownEvents(broadcaster record.EventBroadcaster, watcher watch.Interface, sink *eventSink, timeout time.Duration) {
+	broadcaster.Shutdown()
+
+	deadline := time.Now().Add(timeout)
+	// the watcher may have taken an Event from its channel without writing it
+	// yet, the sink must be idle twice in a row
+	for idle := 0; idle < 2; {
+		if len(watcher.ResultChan()) == 0 && atomic.LoadInt32(&sink.inflight) == 0 {
+			idle++
+		} else {
+			idle = 0
+		}
+		if time.Now().After(deadline) {
+			log.Warnf("events: flush timed out after %s, %d events dropped", timeout, len(watcher.ResultChan()))
+			return
+		}
+		time.Sleep(eventFlushPollInterval)
+	}
+}
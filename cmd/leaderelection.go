@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+type leaderElectionConfig struct {
+	enabled       bool
+	namespace     string
+	name          string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	watchdog *leaderelection.HealthzAdaptor
+}
+
+// runWithLeaderElection blocks until the Lease is acquired, then calls run with
+// a context which is cancelled when ctx is done or the leadership is lost. An
+// error is returned if the leadership is lost before ctx is done, so the
+// process exits and the controller is restarted as a standby replica.
+//
+// The Lease is only released once run has returned, so a standby does not
+// take over while the in-flight reconciles are drained. If the leadership is
+// lost, a standby may take over at any time and abort is called right away
+// instead.
+func runWithLeaderElection(ctx context.Context, kubeClient kubernetes.Interface, cfg leaderElectionConfig, run func(ctx context.Context) error, abort func()) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("get hostname: %v", err)
+	}
+	id := hostname + "_" + string(uuid.NewUUID())
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      cfg.name,
+			Namespace: cfg.namespace,
+		},
+		Client:     kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: id},
+	}
+
+	var started int32
+	errCh := make(chan error, 1)
+	// the elector outlives ctx until run has returned
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+			// a leader releases the Lease once run has returned
+			if atomic.LoadInt32(&started) == 0 {
+				cancel()
+			}
+		case <-leaderCtx.Done():
+		}
+	}()
+
+	log.Infof("leader election: %s is waiting for lease %s/%s", id, cfg.namespace, cfg.name)
+	leaderelection.RunOrDie(leaderCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            cfg.name,
+		LeaseDuration:   cfg.leaseDuration,
+		RenewDeadline:   cfg.renewDeadline,
+		RetryPeriod:     cfg.retryPeriod,
+		ReleaseOnCancel: true,
+		WatchDog:        cfg.watchdog,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leading context.Context) {
+				atomic.StoreInt32(&started, 1)
+				log.Infof("leader election: %s started leading", id)
+				runCtx, runCancel := context.WithCancel(leading)
+				defer runCancel()
+				returned := make(chan struct{})
+				go func() {
+					select {
+					case <-ctx.Done():
+						// drain the in-flight reconciles while the Lease is held
+						runCancel()
+					case <-leading.Done():
+					case <-returned:
+						return
+					}
+					select {
+					case <-leading.Done():
+					case <-returned:
+						return
+					}
+					select {
+					case <-returned:
+						// the Lease is released once run has returned
+					default:
+						log.Warnf("leader election: %s lost the lease", id)
+						abort()
+					}
+				}()
+				err := run(runCtx)
+				close(returned)
+				errCh <- err
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				log.Infof("leader election: %s stopped leading", id)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					log.Infof("leader election: current leader is %s", identity)
+				}
+			},
+		},
+	})
+
+	if atomic.LoadInt32(&started) == 1 {
+		if err = <-errCh; err != nil {
+			return err
+		}
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("leader election lost")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+// reloadLogLevel sets the log level from the file at path, e.g. a key of a
+// mounted ConfigMap, each time the process receives SIGHUP until ctx is done.
+func reloadLogLevel(ctx context.Context, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				loadLogLevel(path)
+			}
+		}
+	}()
+}
+
+func loadLogLevel(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Errorf("log level: read %s failed: %v", path, err)
+		return
+	}
+	if err = log.SetLevel(string(data)); err != nil {
+		log.Errorf("log level: invalid level in %s: %v", path, err)
+		return
+	}
+	log.Infof("log level: set to %s from %s", log.Level().Level(), path)
+}
@@ -1,32 +1,69 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"math"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 
 	foo_clientset "github.com/vietanhduong/xcontroller/pkg/client/clientset/versioned"
 	"github.com/vietanhduong/xcontroller/pkg/controller"
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
 	"github.com/vietanhduong/xcontroller/pkg/util/env"
+	"github.com/vietanhduong/xcontroller/pkg/util/inflight"
 	"github.com/vietanhduong/xcontroller/pkg/util/log"
 )
 
 func newCommand() *cobra.Command {
 	var (
-		kubeconfig string
-		logLevel   string
-		worker     int
+		kubeconfig   string
+		logLevel     string
+		logFormat    string
+		logLevelFile string
+		worker       int
+		maxWorkers   int
+		maxInflight  int
+		metricsAddr  string
+		probeAddr    string
+		debugAddr    string
+		stallTimeout time.Duration
+		gracePeriod  time.Duration
+		resync       time.Duration
+		timeout      time.Duration
+
+		retryBaseDelay time.Duration
+		retryMaxDelay  time.Duration
+		rateLimitQPS   float64
+		rateLimitBurst int
+
+		quarantineThreshold  int
+		namespaceConcurrency int
+		mutationDetector     string
+
+		namespaces         []string
+		barSelector        string
+		deploymentSelector string
+
+		leaderElection leaderElectionConfig
+		sharding       controller.ShardConfig
 
 		cfg        *rest.Config
 		kubeClient *kubernetes.Clientset
@@ -36,15 +73,46 @@ func newCommand() *cobra.Command {
 		Use:          "xcontroller",
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
-			logger := log.NewLogger(logLevel)
+			switch logFormat {
+			case log.FormatConsole, log.FormatJSON:
+			default:
+				return fmt.Errorf("invalid --log-format %q, must be one of console or json", logFormat)
+			}
+			logger := log.NewLogger(logLevel, logFormat)
 			zap.ReplaceGlobals(logger)
-			klog.SetLogger(log.NewK8sLogger(logLevel))
+			klog.SetLogger(log.NewK8sLogger(logLevel, logFormat))
+			if logLevelFile != "" {
+				loadLogLevel(logLevelFile)
+				reloadLogLevel(cmd.Context(), logLevelFile)
+			}
+
+			if leaderElection.enabled && sharding.Group != "" {
+				return fmt.Errorf("--leader-elect and --shard-group are mutually exclusive")
+			}
+
+			switch mutationDetector {
+			case controller.MutationDetectorOff, controller.MutationDetectorLog, controller.MutationDetectorPanic:
+			default:
+				return fmt.Errorf("invalid --cache-mutation-detector %q, must be one of off, log or panic", mutationDetector)
+			}
+
+			// an invalid selector fails every list of the informers and blocks
+			// the cache sync forever
+			if _, err = labels.Parse(barSelector); err != nil {
+				return fmt.Errorf("invalid --bar-selector %q: %w", barSelector, err)
+			}
+			if _, err = labels.Parse(deploymentSelector); err != nil {
+				return fmt.Errorf("invalid --deployment-selector %q: %w", deploymentSelector, err)
+			}
 
 			if cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig); err != nil {
 				return err
 			}
+			// the budget is shared by both clients
+			limiter := inflight.NewLimiter(maxInflight)
+			cfg.Wrap(limiter)
 
-			if kubeClient, err = getKubeConfig(kubeconfig); err != nil {
+			if kubeClient, err = getKubeConfig(kubeconfig, limiter); err != nil {
 				return err
 			}
 
@@ -54,23 +122,115 @@ func newCommand() *cobra.Command {
 
 			eventBroadcaster := record.NewBroadcaster()
 			eventBroadcaster.StartStructuredLogging(0)
-			eventBroadcaster.StartRecordingToSink(&typev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events(metav1.NamespaceAll)})
+			sink := &eventSink{EventSink: &typev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events(metav1.NamespaceAll)}}
+			sinkWatcher := eventBroadcaster.StartRecordingToSink(sink)
 			recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "xcontroller"})
 
-			ctrl := controller.NewController(cmd.Context(), fooClient, kubeClient, recorder)
-			return ctrl.Run(worker)
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			serve(cmd.Context(), "metrics", metricsAddr, mux)
+
+			// the endpoints changing the state of the controller are served
+			// apart from the metrics, on localhost by default
+			debug := http.NewServeMux()
+			debug.Handle("/debug/loglevel", log.Level())
+			serve(cmd.Context(), "debug", debugAddr, debug)
+
+			// flush the Events recorded while draining the workers
+			defer shutdownEvents(eventBroadcaster, sinkWatcher, sink, eventFlushTimeout)
+
+			opts := []controller.Option{
+				controller.WithShutdownGracePeriod(gracePeriod),
+				controller.WithMaxWorkers(maxWorkers),
+				controller.WithResyncPeriod(resync),
+				controller.WithReconcileTimeout(timeout),
+				controller.WithRetryBackoff(retryBaseDelay, retryMaxDelay),
+				controller.WithRateLimit(rateLimitQPS, rateLimitBurst),
+				controller.WithQuarantineThreshold(quarantineThreshold),
+				controller.WithNamespaceConcurrency(namespaceConcurrency),
+				controller.WithCacheMutationDetector(mutationDetector),
+				controller.WithNamespaces(namespaces...),
+				controller.WithBarSelector(barSelector),
+				controller.WithDeploymentSelector(deploymentSelector),
+			}
+			if sharding.Group != "" {
+				if sharding.Identity == "" {
+					if sharding.Identity, err = os.Hostname(); err != nil {
+						return fmt.Errorf("get hostname: %v", err)
+					}
+				}
+				opts = append(opts, controller.WithSharding(sharding))
+			}
+			ctrl := controller.NewController(cmd.Context(), fooClient, kubeClient, recorder, opts...)
+
+			healthChecks := []func() error{func() error { return ctrl.Healthy(stallTimeout) }}
+			if leaderElection.enabled {
+				leaderElection.watchdog = leaderelection.NewLeaderHealthzAdaptor(leaderElection.leaseDuration)
+				healthChecks = append(healthChecks, func() error { return leaderElection.watchdog.Check(nil) })
+			}
+			probes := http.NewServeMux()
+			probes.Handle("/healthz", checkHandler(healthChecks...))
+			probes.Handle("/readyz", checkHandler(ctrl.Ready))
+			serve(cmd.Context(), "health probe", probeAddr, probes)
+
+			if err = ctrl.WaitForCacheSync(cmd.Context()); err != nil {
+				return err
+			}
+
+			if !leaderElection.enabled {
+				return ctrl.Run(cmd.Context(), worker)
+			}
+			return runWithLeaderElection(cmd.Context(), kubeClient, leaderElection, func(ctx context.Context) error {
+				return ctrl.Run(ctx, worker)
+			}, ctrl.Abort)
 		},
 	}
 	cmd.Flags().StringVar(&kubeconfig, "kubeconfig", env.StringFromEnv("KUBECONFIG", ""), "Full path to kubernetes client configuration, i.e. ~/.kube/config")
 	cmd.Flags().StringVar(&logLevel, "log-level", env.StringFromEnv("LOG_LEVEL", "info"), "Log level")
+	cmd.Flags().StringVar(&logFormat, "log-format", env.StringFromEnv("LOG_FORMAT", log.FormatConsole), "Log format of the controller and the Kubernetes client logs: console or json")
+	cmd.Flags().StringVar(&logLevelFile, "log-level-file", env.StringFromEnv("LOG_LEVEL_FILE", ""), "File holding the log level, read on startup and on SIGHUP. It takes precedence over --log-level")
 	cmd.Flags().IntVar(&worker, "workers", env.ParseNumFromEnv("WORKERS", 10, 1, math.MaxInt32), "Number of workers")
+	cmd.Flags().IntVar(&maxWorkers, "max-workers", env.ParseNumFromEnv("MAX_WORKERS", 0, 0, math.MaxInt32), "Maximum number of workers, the pool grows from --workers up to it while the queue is backed up. 0 for a fixed pool")
+	cmd.Flags().IntVar(&maxInflight, "max-inflight-requests", env.ParseNumFromEnv("MAX_INFLIGHT_REQUESTS", 0, 0, math.MaxInt32), "Maximum number of Kubernetes API requests in flight, watches excluded. 0 for no limit")
+	cmd.Flags().StringVar(&metricsAddr, "metrics-bind-address", env.StringFromEnv("METRICS_BIND_ADDRESS", ":8080"), "Address the /metrics endpoint binds to, empty to disable it")
+	cmd.Flags().StringVar(&probeAddr, "health-probe-bind-address", env.StringFromEnv("HEALTH_PROBE_BIND_ADDRESS", ":8081"), "Address the /healthz and /readyz endpoints bind to, empty to disable them")
+	cmd.Flags().StringVar(&debugAddr, "debug-bind-address", env.StringFromEnv("DEBUG_BIND_ADDRESS", "127.0.0.1:8082"), "Address the /debug/loglevel endpoint binds to, it reads the log level on GET and changes it on PUT without authentication. Keep it on localhost and use kubectl port-forward, empty to disable it")
+	cmd.Flags().DurationVar(&stallTimeout, "liveness-stall-timeout", env.ParseDurationFromEnv("LIVENESS_STALL_TIMEOUT", 5*time.Minute, time.Second, 24*time.Hour), "Duration the workers may not drain a non-empty queue before the liveness probe fails")
+	cmd.Flags().DurationVar(&gracePeriod, "shutdown-grace-period", env.ParseDurationFromEnv("SHUTDOWN_GRACE_PERIOD", 30*time.Second, 0, time.Hour), "Duration to wait for in-flight reconciles on shutdown")
+	cmd.Flags().DurationVar(&resync, "resync-period", env.ParseDurationFromEnv("RESYNC_PERIOD", time.Hour, 0, 24*time.Hour), "Period the informers requeue every Bar, 0 to disable")
+	cmd.Flags().DurationVar(&timeout, "reconcile-timeout", env.ParseDurationFromEnv("RECONCILE_TIMEOUT", time.Minute, 0, time.Hour), "Deadline of the API calls of a reconcile, 0 to disable")
+	cmd.Flags().DurationVar(&retryBaseDelay, "retry-base-delay", env.ParseDurationFromEnv("RETRY_BASE_DELAY", 5*time.Millisecond, time.Millisecond, time.Hour), "Initial delay before retrying a failed Bar, doubled on each failure")
+	cmd.Flags().DurationVar(&retryMaxDelay, "retry-max-delay", env.ParseDurationFromEnv("RETRY_MAX_DELAY", 1000*time.Second, time.Millisecond, 24*time.Hour), "Maximum delay before retrying a failed Bar")
+	cmd.Flags().Float64Var(&rateLimitQPS, "rate-limit-qps", env.ParseFloatFromEnv("RATE_LIMIT_QPS", 10, 0.001, math.MaxFloat32), "Overall rate of requeued Bars per second")
+	cmd.Flags().IntVar(&rateLimitBurst, "rate-limit-burst", env.ParseNumFromEnv("RATE_LIMIT_BURST", 100, 1, math.MaxInt32), "Burst of requeued Bars above the rate limit")
+	cmd.Flags().IntVar(&quarantineThreshold, "quarantine-threshold", env.ParseNumFromEnv("QUARANTINE_THRESHOLD", 15, 0, math.MaxInt32), "Number of consecutive failed reconciles after which a Bar is quarantined until its spec changes, 0 to disable")
+	cmd.Flags().IntVar(&namespaceConcurrency, "max-concurrent-reconciles-per-namespace", env.ParseNumFromEnv("MAX_CONCURRENT_RECONCILES_PER_NAMESPACE", 0, 0, math.MaxInt32), "Maximum number of Bars of a namespace reconciled concurrently, 0 for no limit")
+	cmd.Flags().StringVar(&mutationDetector, "cache-mutation-detector", env.StringFromEnv("CACHE_MUTATION_DETECTOR", controller.MutationDetectorOff), "Debug mode reporting the informer cache objects modified by the controller: off, log or panic")
+	cmd.Flags().StringSliceVar(&namespaces, "namespaces", env.StringsFromEnv("NAMESPACES", nil), "Namespaces to watch, all namespaces if empty")
+	cmd.Flags().StringVar(&barSelector, "bar-selector", env.StringFromEnv("BAR_SELECTOR", ""), "Label selector of the Bars to reconcile")
+	cmd.Flags().StringVar(&deploymentSelector, "deployment-selector", env.StringFromEnv("DEPLOYMENT_SELECTOR", ""), "Label selector of the Deployments to watch, in addition to app.kubernetes.io/managed-by=xcontroller")
+	cmd.Flags().BoolVar(&leaderElection.enabled, "leader-elect", env.ParseBoolFromEnv("LEADER_ELECT", false), "Enable leader election, only the leader replica processes Bars")
+	cmd.Flags().StringVar(&leaderElection.namespace, "leader-election-namespace", env.StringFromEnv("LEADER_ELECTION_NAMESPACE", "default"), "Namespace of the leader election Lease")
+	cmd.Flags().StringVar(&leaderElection.name, "leader-election-name", env.StringFromEnv("LEADER_ELECTION_NAME", "xcontroller"), "Name of the leader election Lease")
+	cmd.Flags().DurationVar(&leaderElection.leaseDuration, "leader-election-lease-duration", env.ParseDurationFromEnv("LEADER_ELECTION_LEASE_DURATION", 15*time.Second, time.Second, time.Hour), "Duration standby replicas wait before taking over a non-renewed Lease")
+	cmd.Flags().DurationVar(&leaderElection.renewDeadline, "leader-election-renew-deadline", env.ParseDurationFromEnv("LEADER_ELECTION_RENEW_DEADLINE", 10*time.Second, time.Second, time.Hour), "Duration the leader retries renewing the Lease before giving up")
+	cmd.Flags().StringVar(&sharding.Group, "shard-group", env.StringFromEnv("SHARD_GROUP", ""), "Name of the shard group, the Bars are split between the replicas of the group. Empty to disable sharding")
+	cmd.Flags().StringVar(&sharding.Namespace, "shard-namespace", env.StringFromEnv("SHARD_NAMESPACE", "default"), "Namespace of the shard member Leases")
+	cmd.Flags().StringVar(&sharding.Identity, "shard-identity", env.StringFromEnv("SHARD_IDENTITY", ""), "Unique name of the replica in the shard group, defaults to the hostname")
+	cmd.Flags().DurationVar(&sharding.LeaseDuration, "shard-lease-duration", env.ParseDurationFromEnv("SHARD_LEASE_DURATION", 15*time.Second, time.Second, time.Hour), "Duration after which a replica which stopped renewing its Lease leaves the shard group")
+	cmd.Flags().DurationVar(&sharding.RenewPeriod, "shard-renew-period", env.ParseDurationFromEnv("SHARD_RENEW_PERIOD", 5*time.Second, 100*time.Millisecond, time.Hour), "Interval the shard member Lease is renewed and the members are listed at")
+	cmd.Flags().DurationVar(&sharding.HandoffDelay, "shard-handoff-delay", env.ParseDurationFromEnv("SHARD_HANDOFF_DELAY", 0, 0, time.Hour), "Duration to wait before processing the Bars gained from another replica, defaults to the reconcile timeout plus the lease duration")
+	cmd.Flags().DurationVar(&leaderElection.retryPeriod, "leader-election-retry-period", env.ParseDurationFromEnv("LEADER_ELECTION_RETRY_PERIOD", 2*time.Second, 100*time.Millisecond, time.Hour), "Duration between leader election actions")
 
 	return cmd
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	cmd := newCommand()
-	if err := cmd.Execute(); err != nil {
+	err := cmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		os.Exit(1)
 	}
 }
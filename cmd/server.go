@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+// serve starts an HTTP server on addr in the background and shuts it down
+// when ctx is done. An empty addr disables the server.
+func serve(ctx context.Context, name, addr string, handler http.Handler) {
+	if addr == "" {
+		return
+	}
+
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Infof("%s server: listening on %s", name, addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("%s server: %v", name, err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("%s server: shutdown failed: %v", name, err)
+		}
+	}()
+}
+
+// checkHandler serves 200 if all checks pass, otherwise 500 with the error of
+// the first failed check.
+func checkHandler(checks ...func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, check := range checks {
+			if err := check(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+}
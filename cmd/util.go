@@ -7,13 +7,14 @@ import (
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/transport"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"github.com/vietanhduong/xcontroller/pkg/util/log"
 )
 
-func getKubeConfig(kubeConfig string) (*kubernetes.Clientset, error) {
+func getKubeConfig(kubeConfig string, wrap transport.WrapperFunc) (*kubernetes.Clientset, error) {
 	var fullKubeConfigPath string
 	var err error
 
@@ -30,10 +31,10 @@ func getKubeConfig(kubeConfig string) (*kubernetes.Clientset, error) {
 		log.Debugf("Creating in-cluster Kubernetes client")
 	}
 
-	return newKubeClient(fullKubeConfigPath)
+	return newKubeClient(fullKubeConfigPath, wrap)
 }
 
-func newKubeClient(kubeconfig string) (*kubernetes.Clientset, error) {
+func newKubeClient(kubeconfig string, wrap transport.WrapperFunc) (*kubernetes.Clientset, error) {
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 	loadingRules.DefaultClientConfig = &clientcmd.DefaultClientConfig
 	loadingRules.ExplicitPath = kubeconfig
@@ -44,6 +45,7 @@ func newKubeClient(kubeconfig string) (*kubernetes.Clientset, error) {
 	if err != nil {
 		return nil, err
 	}
+	config.Wrap(wrap)
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
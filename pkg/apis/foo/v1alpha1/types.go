@@ -7,6 +7,8 @@ import (
 )
 
 // +genclient
+// +genclient:method=GetScale,verb=get,subresource=scale,result=k8s.io/api/autoscaling/v1.Scale
+// +genclient:method=UpdateScale,verb=update,subresource=scale,input=k8s.io/api/autoscaling/v1.Scale,result=k8s.io/api/autoscaling/v1.Scale
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 type Bar struct {
@@ -18,12 +20,98 @@ type Bar struct {
 }
 
 type BarStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	ReadyReplicas int32  `json:"readyReplicas"`
 	Ready         string `json:"ready"`
 	Success       bool   `json:"success"`
 	Message       string `json:"message"`
+
+	// UpdatedReplicas, AvailableReplicas and UnavailableReplicas mirror the
+	// rollout progress of the Deployment of the Bar.
+	UpdatedReplicas     int32 `json:"updatedReplicas,omitempty"`
+	AvailableReplicas   int32 `json:"availableReplicas,omitempty"`
+	UnavailableReplicas int32 `json:"unavailableReplicas,omitempty"`
+
+	// CurrentImage is the image of the last completed rollout.
+	CurrentImage string `json:"currentImage,omitempty"`
+	// DesiredImage is the image the Bar is rolling out to.
+	DesiredImage string `json:"desiredImage,omitempty"`
+
+	// RolledBackImage is the image whose rollout failed and was automatically
+	// rolled back at RolledBackGeneration. The image is not retried while the
+	// spec of the Bar still requests it.
+	RolledBackImage      string `json:"rolledBackImage,omitempty"`
+	RolledBackGeneration int64  `json:"rolledBackGeneration,omitempty"`
+
+	// Selector is the label selector of the pods managed by the Bar, it is
+	// used by the scale subresource.
+	Selector string `json:"selector,omitempty"`
+
+	// Conditions represent the latest available observations of the Bar's state.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// Condition types of a Bar.
+const (
+	// ConditionReady indicates all desired replicas of the Bar are ready.
+	ConditionReady = "Ready"
+	// ConditionProgressing indicates the Deployment of the Bar is rolling out.
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded indicates the Bar cannot reach its desired state.
+	ConditionDegraded = "Degraded"
+	// ConditionReconciled indicates the last reconcile of the Bar succeeded.
+	ConditionReconciled = "Reconciled"
+	// ConditionStalled indicates the last reconcile of the Bar failed with an
+	// error that cannot be resolved by retrying, the Bar is not reconciled
+	// again until its spec changes.
+	ConditionStalled = "Stalled"
+	// ConditionQuarantined indicates the Bar failed or panicked too many times
+	// in a row, the Bar is not reconciled again until its spec changes.
+	ConditionQuarantined = "Quarantined"
+)
+
+// Condition reasons of a Bar.
+const (
+	ReasonReconcileSucceeded = "ReconcileSucceeded"
+	ReasonReconcileFailed    = "ReconcileFailed"
+	ReasonReplicasReady      = "ReplicasReady"
+	ReasonReplicasNotReady   = "ReplicasNotReady"
+	ReasonRolloutInProgress  = "RolloutInProgress"
+	ReasonRolloutComplete    = "RolloutComplete"
+	ReasonAsExpected         = "AsExpected"
+
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	ReasonCrashLoop                = "CrashLoop"
+	ReasonRolledBack               = "RolledBack"
+
+	// reasons of terminal reconcile errors
+	ReasonInvalidSpec = "InvalidSpec"
+	ReasonForbidden   = "Forbidden"
+	ReasonRejected    = "Rejected"
+
+	ReasonPanic            = "Panic"
+	ReasonTooManyFailures  = "TooManyFailures"
+	ReasonReconcileTimeout = "ReconcileTimeout"
+)
+
+// BarFinalizer is the finalizer added to every Bar so the controller can clean
+// up its children according to the deletion policy.
+const BarFinalizer = "foo.anhdv.dev/finalizer"
+
+// Deletion policies of a Bar.
+const (
+	// DeletionPolicyDelete scales the children down gracefully and deletes them.
+	DeletionPolicyDelete = "Delete"
+	// DeletionPolicyOrphan keeps the children running and releases them
+	// from the controller.
+	DeletionPolicyOrphan = "Orphan"
+	// DeletionPolicyRetain keeps the children running, a Bar recreated with
+	// the same name adopts them again.
+	DeletionPolicyRetain = "Retain"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 type BarList struct {
@@ -24,6 +24,7 @@ import (
 
 	v1alpha1 "github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
 	scheme "github.com/vietanhduong/xcontroller/pkg/client/clientset/versioned/scheme"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	types "k8s.io/apimachinery/pkg/types"
 	watch "k8s.io/apimachinery/pkg/watch"
@@ -47,6 +48,9 @@ type BarInterface interface {
 	List(ctx context.Context, opts v1.ListOptions) (*v1alpha1.BarList, error)
 	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
 	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.Bar, err error)
+	GetScale(ctx context.Context, barName string, options v1.GetOptions) (*autoscalingv1.Scale, error)
+	UpdateScale(ctx context.Context, barName string, scale *autoscalingv1.Scale, opts v1.UpdateOptions) (*autoscalingv1.Scale, error)
+
 	BarExpansion
 }
 
@@ -193,3 +197,32 @@ func (c *bars) Patch(ctx context.Context, name string, pt types.PatchType, data
 		Into(result)
 	return
 }
+
+// GetScale takes name of the bar, and returns the corresponding autoscalingv1.Scale object, and an error if there is any.
+func (c *bars) GetScale(ctx context.Context, barName string, options v1.GetOptions) (result *autoscalingv1.Scale, err error) {
+	result = &autoscalingv1.Scale{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("bars").
+		Name(barName).
+		SubResource("scale").
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// UpdateScale takes the top resource name and the representation of a scale and updates it. Returns the server's representation of the scale, and an error, if there is any.
+func (c *bars) UpdateScale(ctx context.Context, barName string, scale *autoscalingv1.Scale, opts v1.UpdateOptions) (result *autoscalingv1.Scale, err error) {
+	result = &autoscalingv1.Scale{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("bars").
+		Name(barName).
+		SubResource("scale").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(scale).
+		Do(ctx).
+		Into(result)
+	return
+}
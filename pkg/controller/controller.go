@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -22,139 +24,410 @@ import (
 	foo_scheme "github.com/vietanhduong/xcontroller/pkg/client/clientset/versioned/scheme"
 	foo_informers "github.com/vietanhduong/xcontroller/pkg/client/informers/externalversions"
 	foo_listers "github.com/vietanhduong/xcontroller/pkg/client/listers/foo/v1alpha1"
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
 	"github.com/vietanhduong/xcontroller/pkg/util/log"
 
 	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
 )
 
 type Controller struct {
-	ctx context.Context
+	// ctx is the context of the reconciles, cancel aborts them
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   options
 
 	fooClient  foo_clientset.Interface
 	kubeClient kubernetes.Interface
 
 	queue workqueue.RateLimitingInterface
+	fair  *fairQueue
 
-	barInformer cache.SharedIndexInformer
-	barLister   foo_listers.BarLister
+	barInformers []cache.SharedIndexInformer
+	barLister    foo_listers.BarLister
 
-	deployInformer cache.SharedIndexInformer
-	deployLister   app_listers.DeploymentLister
+	deployInformers []cache.SharedIndexInformer
+	deployLister    app_listers.DeploymentLister
 
 	recorder record.EventRecorder
+
+	failures *failureTracker
+
+	// statusVersions holds the resourceVersion returned by the last status
+	// patch of each Bar key
+	statusVersions sync.Map
+
+	// shards is nil if sharding is disabled
+	shards *sharder
+
+	// mutations is nil if the cache mutation detector is disabled
+	mutations *mutationDetector
+
+	// synced is set to 1 once the informer caches are synced, running is set to
+	// 1 while the workers are running and lastProcessed is the unix nano time a
+	// worker last finished processing an item.
+	synced        int32
+	running       int32
+	lastProcessed int64
 }
 
-func NewController(ctx context.Context, fooClient foo_clientset.Interface, kubeClient kubernetes.Interface, recorder record.EventRecorder) *Controller {
+func NewController(ctx context.Context, fooClient foo_clientset.Interface, kubeClient kubernetes.Interface, recorder record.EventRecorder, opts ...Option) *Controller {
 	utilruntime.Must(foo_scheme.AddToScheme(scheme.Scheme))
 	log.Info("Creating event broadcaster...")
 
-	fooInformerFactory := foo_informers.NewSharedInformerFactory(fooClient, time.Hour)
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Hour)
-
-	barInformer := fooInformerFactory.Foo().V1alpha1().Bars()
-	deployInformer := kubeInformerFactory.Apps().V1().Deployments()
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
 
+	workCtx, cancel := context.WithCancel(context.Background())
 	controller := &Controller{
-		ctx:            ctx,
-		fooClient:      fooClient,
-		kubeClient:     kubeClient,
-		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Bar_queue"),
-		barInformer:    barInformer.Informer(),
-		barLister:      barInformer.Lister(),
-		deployInformer: deployInformer.Informer(),
-		deployLister:   deployInformer.Lister(),
-		recorder:       recorder,
-	}
-
-	controller.barInformer.AddEventHandler(addFooResourceHandlerFunc(controller.queue))
-	controller.deployInformer.AddEventHandler(controller.addK8sResourceHandlerFunc())
-
-	fooInformerFactory.Start(ctx.Done())
-	kubeInformerFactory.Start(ctx.Done())
+		ctx:        workCtx,
+		cancel:     cancel,
+		opts:       o,
+		fooClient:  fooClient,
+		kubeClient: kubeClient,
+		recorder:   recorder,
+		failures:   newFailureTracker(),
+	}
+
+	if o.sharding != nil {
+		cfg := *o.sharding
+		if cfg.HandoffDelay <= 0 {
+			cfg.HandoffDelay = o.reconcileTimeout + cfg.LeaseDuration
+		}
+		controller.shards = newSharder(cfg, kubeClient)
+	}
+
+	// Bars are handed out round-robin across namespaces
+	controller.fair = newFairQueue("Bar_queue", o.maxPerNamespace)
+	queue := workqueue.NewDelayingQueueWithCustomQueue(controller.fair, "Bar_queue")
+	controller.queue = workqueue.NewRateLimitingQueueWithDelayingInterface(queue, o.rateLimiter())
+
+	namespaces := o.namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	if o.mutationDetector != "" && o.mutationDetector != MutationDetectorOff {
+		controller.mutations = newMutationDetector(o.mutationDetector)
+		go controller.mutations.run(ctx)
+	}
+
+	barListers := multiNamespaceBarLister{}
+	deployListers := multiNamespaceDeploymentLister{}
+	for _, namespace := range namespaces {
+		fooInformerFactory := foo_informers.NewSharedInformerFactoryWithOptions(fooClient, o.resyncPeriod,
+			foo_informers.WithNamespace(namespace),
+			foo_informers.WithTweakListOptions(withLabelSelector(o.barSelector)),
+		)
+		kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, o.resyncPeriod,
+			kubeinformers.WithNamespace(namespace),
+			kubeinformers.WithTweakListOptions(withLabelSelector(childSelector(o.deploymentSelector))),
+		)
+
+		barInformer := fooInformerFactory.Foo().V1alpha1().Bars()
+		deployInformer := kubeInformerFactory.Apps().V1().Deployments()
+
+		utilruntime.Must(barInformer.Informer().SetTransform(stripObject))
+		setupChildInformer(deployInformer.Informer())
+
+		if controller.mutations != nil {
+			controller.mutations.watch(barInformer.Informer(), "Bar")
+			controller.mutations.watch(deployInformer.Informer(), "Deployment")
+		}
+		barInformer.Informer().AddEventHandler(controller.addFooResourceHandlerFunc())
+		deployInformer.Informer().AddEventHandler(controller.addK8sResourceHandlerFunc())
+
+		controller.barInformers = append(controller.barInformers, barInformer.Informer())
+		controller.deployInformers = append(controller.deployInformers, deployInformer.Informer())
+		barListers[namespace] = barInformer.Lister()
+		deployListers[namespace] = deployInformer.Lister()
+
+		fooInformerFactory.Start(ctx.Done())
+		kubeInformerFactory.Start(ctx.Done())
+	}
+
+	// the reconciles may modify the objects they get, never the cached ones
+	controller.barLister = deepCopyBarLister{lister: barListers}
+	controller.deployLister = deepCopyDeploymentLister{lister: deployListers}
 	return controller
 }
 
-func (c *Controller) Run(worker int) error {
+// withLabelSelector returns a function which sets the label selector of the
+// list and watch requests of an informer.
+func withLabelSelector(selector string) func(*metav1.ListOptions) {
+	return func(opts *metav1.ListOptions) {
+		opts.LabelSelector = selector
+	}
+}
+
+// Run starts the workers and blocks until ctx is done. The informers are
+// started by NewController, so a standby replica keeps its caches warm
+// before Run is called.
+//
+// The pool starts with the given number of workers. If the max workers option
+// is greater, the pool grows up to it while the queue is backed up and
+// shrinks back once the queue is idle.
+//
+// Once ctx is done, the workers stop taking new keys from the queue and Run
+// waits up to the shutdown grace period for in-flight reconciles. The API
+// calls of these reconciles are only cancelled when the grace period expires
+// or Abort is called.
+func (c *Controller) Run(ctx context.Context, worker int) error {
 	defer utilruntime.HandleCrash()
 	defer c.queue.ShutDown()
 
-	if ok := cache.WaitForCacheSync(c.ctx.Done(), c.barInformer.HasSynced, c.deployInformer.HasSynced); !ok {
+	if err := c.WaitForCacheSync(ctx); err != nil {
+		return err
+	}
+
+	defer c.cancel()
+
+	atomic.StoreInt64(&c.lastProcessed, time.Now().UnixNano())
+	atomic.StoreInt32(&c.running, 1)
+	defer atomic.StoreInt32(&c.running, 0)
+
+	if c.shards != nil {
+		go c.shards.run(ctx, c.enqueueOwned)
+	}
+
+	pool := newWorkerPool(c.processItem)
+	pool.resize(worker)
+	go c.dispatch(pool)
+	if c.opts.maxWorkers > worker {
+		go c.autoscale(ctx, pool, worker, c.opts.maxWorkers)
+	}
+
+	<-ctx.Done()
+	log.Infof("controller: shutting down, waiting up to %s for in-flight reconciles", c.opts.shutdownGracePeriod)
+	c.queue.ShutDown()
+
+	done := make(chan struct{})
+	go func() {
+		pool.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("controller: all workers stopped")
+	case <-time.After(c.opts.shutdownGracePeriod):
+		log.Warnf("controller: shutdown grace period exceeded, cancelling in-flight reconciles")
+	}
+	return nil
+}
+
+// Abort cancels the API calls of the in-flight reconciles right away, without
+// waiting for the shutdown grace period. It is called when the leadership is
+// lost, another replica may already reconcile the same Bars.
+func (c *Controller) Abort() {
+	log.Warn("controller: cancelling in-flight reconciles")
+	c.cancel()
+}
+
+// WaitForCacheSync blocks until the Bar and Deployment informer caches are
+// synced or ctx is done.
+func (c *Controller) WaitForCacheSync(ctx context.Context) error {
+	var synced []cache.InformerSynced
+	for _, informer := range append(c.barInformers, c.deployInformers...) {
+		synced = append(synced, informer.HasSynced)
+	}
+	if ok := cache.WaitForCacheSync(ctx.Done(), synced...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
+	atomic.StoreInt32(&c.synced, 1)
+	return nil
+}
 
-	for i := 0; i < worker; i++ {
-		go wait.Until(func() {
-			for c.processItemQueue() {
-			}
-		}, time.Second, c.ctx.Done())
+// Ready returns an error until the informer caches are synced.
+func (c *Controller) Ready() error {
+	if atomic.LoadInt32(&c.synced) == 0 {
+		return fmt.Errorf("informer caches are not synced")
 	}
+	return nil
+}
 
-	<-c.ctx.Done()
+// Healthy returns an error if the workers are running but have not finished
+// processing any item for longer than timeout while items are queued.
+func (c *Controller) Healthy(timeout time.Duration) error {
+	if atomic.LoadInt32(&c.running) == 0 || c.queue.Len() == 0 {
+		return nil
+	}
+	if idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastProcessed))); idle > timeout {
+		return fmt.Errorf("workers have not processed any item for %s with %d items queued", idle.Round(time.Second), c.queue.Len())
+	}
 	return nil
 }
 
-func (c *Controller) processItemQueue() bool {
-	obj, quit := c.queue.Get()
-	if quit {
-		return false
+// processItem reconciles an item taken from the queue.
+func (c *Controller) processItem(obj interface{}) {
+	// the queue is shutting down, do not start a new reconcile
+	if c.queue.ShuttingDown() {
+		c.queue.Done(obj)
+		return
 	}
 
-	err := func(obj interface{}) error {
+	key, ok := obj.(string)
+	if !ok {
+		c.queue.Forget(obj)
+		c.queue.Done(obj)
+		log.Errorf("controller/queue: expected string in workqueue but got %#v", obj)
+		return
+	}
+
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+	logger := log.With("namespace", namespace, "name", name, "reconcileID", uuid.NewUUID())
+
+	func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Errorf("controller/queue: recovered from panic: %+v\n%s", r, debug.Stack())
+				logger.Error("recovered from panic", "panic", r, "stack", string(debug.Stack()))
+				c.queue.AddRateLimited(obj)
 			}
 			c.queue.Done(obj)
+			atomic.StoreInt64(&c.lastProcessed, time.Now().UnixNano())
 		}()
 
-		var key string
-		var ok bool
-		if key, ok = obj.(string); !ok {
-			c.queue.Forget(obj)
-			log.Errorf("controller/queue: expected string in workqueue but got %#v", obj)
-			return nil
-		}
+		ctx, cancel := c.reconcileContext(logger)
+		defer cancel()
 
-		if err := c.processBar(key); err != nil {
+		start := time.Now()
+		res, err := c.processBar(ctx, key)
+		if err != nil {
+			reason, terminal := errorReason(err)
+			result := metrics.ResultError
+			if reason == v1alpha1.ReasonReconcileTimeout {
+				result = metrics.ResultTimeout
+			}
+			metrics.ReconcileTotal.WithLabelValues(result).Inc()
+			metrics.ReconcileDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+			if terminal {
+				metrics.ReconcileErrors.WithLabelValues(metrics.ErrorTerminal, reason).Inc()
+				c.failures.reset(key)
+				c.queue.Forget(obj)
+				logger.Error("reconcile failed with a terminal error, waiting for a spec change", "reason", reason, "error", err)
+				return
+			}
+			metrics.ReconcileErrors.WithLabelValues(metrics.ErrorTransient, reason).Inc()
+			if n := c.failures.inc(key); c.opts.quarantineThreshold > 0 && n >= c.opts.quarantineThreshold {
+				c.failures.reset(key)
+				c.queue.Forget(obj)
+				// the reconcile context may have expired
+				qctx, qcancel := c.reconcileContext(logger)
+				defer qcancel()
+				c.quarantineBar(qctx, key, n, err)
+				logger.Error("reconcile failed, quarantined", "reason", reason, "failures", n, "error", err)
+				return
+			}
 			c.queue.AddRateLimited(key)
-			return fmt.Errorf("controler/queue: error syncing '%s': %s, requeuing", key, err.Error())
+			logger.Error("reconcile failed, requeuing", "reason", reason, "error", err)
+			return
 		}
 
-		c.queue.Forget(obj)
-		log.Infof("controller/queue: successfully synced '%s'", key)
-		return nil
-	}(obj)
+		metrics.ReconcileTotal.WithLabelValues(metrics.ResultSuccess).Inc()
+		metrics.ReconcileDuration.WithLabelValues(metrics.ResultSuccess).Observe(time.Since(start).Seconds())
+		c.failures.reset(key)
+		switch {
+		case res.RequeueAfter > 0:
+			c.queue.Forget(obj)
+			c.queue.AddAfter(key, res.RequeueAfter)
+			logger.Info("successfully synced, requeuing", "requeueAfter", res.RequeueAfter)
+		case res.Requeue:
+			c.queue.AddRateLimited(key)
+			logger.Info("successfully synced, requeuing")
+		default:
+			c.queue.Forget(obj)
+			logger.Info("successfully synced")
+		}
+	}()
 
-	if err != nil {
-		log.Errorf("process queue failed: %v", err)
+	// outside of the recover above, so the panic mode stops the controller
+	if c.mutations != nil {
+		c.mutations.check(key, "Bar", "Deployment")
 	}
+}
 
-	return true
+// reconcileContext returns the context of a reconcile, which carries the
+// logger and is cancelled once the reconcile timeout expires.
+func (c *Controller) reconcileContext(logger *log.Logger) (context.Context, context.CancelFunc) {
+	ctx := log.IntoContext(c.ctx, logger)
+	if c.opts.reconcileTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.opts.reconcileTimeout)
 }
 
-func (c *Controller) processBar(key string) error {
+func (c *Controller) processBar(ctx context.Context, key string) (res Result, err error) {
 	var namespace string
 	var name string
 	var b *v1alpha1.Bar
-	var err error
 
 	if namespace, name, err = cache.SplitMetaNamespaceKey(key); err != nil {
-		log.Errorf("invalid resource key: '%s'", key)
-		return nil
+		log.FromContext(ctx).Error("invalid resource key", "key", key)
+		return Result{}, nil
+	}
+
+	// the Bar moved to another shard while it was queued
+	if c.shards != nil && !c.shards.owns(key) {
+		log.FromContext(ctx).Debug("owned by another shard, skipping")
+		return Result{}, nil
 	}
 
 	if b, err = c.barLister.Bars(namespace).Get(name); err != nil {
 		// Object already deleted
 		if errors.IsNotFound(err) {
-			return nil
+			metrics.DeleteBar(namespace, name)
+			c.statusVersions.Delete(key)
+			return Result{}, nil
 		}
-		return err
+		return Result{}, err
 	}
 
-	if err = c.reconcile(b); err != nil {
-		c.onReconcileFailed(b, err)
+	logger := log.FromContext(ctx).With("generation", b.Generation)
+	ctx = log.IntoContext(ctx, logger)
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("recovered from panic", "panic", r, "stack", string(debug.Stack()))
+			res, err = Result{}, &panicError{value: r}
+			fctx, cancel := c.reconcileContext(logger)
+			defer cancel()
+			c.onReconcileFailed(fctx, b, err)
+		}
+	}()
+
+	if isQuarantined(b) {
+		logger.Debug("quarantined, waiting for a spec change")
+		return Result{}, nil
+	}
+	metrics.QuarantinedBars.DeleteLabelValues(namespace, name)
+
+	if b.DeletionTimestamp != nil {
+		res, err = c.finalize(ctx, b)
+		return res, withDeadline(ctx, err)
 	}
-	return err
+
+	if !hasFinalizer(b) {
+		if b, err = c.addFinalizer(ctx, b); err != nil {
+			return Result{}, withDeadline(ctx, err)
+		}
+	}
+
+	if isStalled(b) {
+		logger.Debug("stalled, waiting for a spec change")
+		return Result{}, nil
+	}
+
+	if err = validateBar(b); err == nil {
+		res, err = c.reconcile(ctx, b)
+	}
+	if err = classifyError(withDeadline(ctx, err)); err != nil {
+		// the reconcile context may have expired
+		fctx, cancel := c.reconcileContext(logger)
+		defer cancel()
+		c.onReconcileFailed(fctx, b, err)
+	}
+	return res, err
 }
 
 func (c *Controller) addK8sResourceHandlerFunc() cache.ResourceEventHandlerFuncs {
@@ -168,10 +441,9 @@ func (c *Controller) addK8sResourceHandlerFunc() cache.ResourceEventHandlerFuncs
 				return
 			}
 
-			if o1.GetResourceVersion() != o2.GetResourceVersion() {
+			if o1.GetResourceVersion() != o2.GetResourceVersion() && deploymentUpdatePredicate.allow(oldObj, newObj) {
 				c.handlerK8sObject(newObj)
 			}
-
 		},
 		DeleteFunc: func(obj interface{}) {
 			c.handlerK8sObject(obj)
@@ -199,42 +471,52 @@ func (c *Controller) handlerK8sObject(obj interface{}) {
 	}
 
 	var owner *metav1.OwnerReference
-	if owner = metav1.GetControllerOf(object); owner == nil {
+	if owner = barControllerOf(object); owner == nil {
 		return
 	}
 
-	if owner.Kind == "Bar" {
-		var key string
-		var b *v1alpha1.Bar
-		var err error
-		if b, err = c.barLister.Bars(object.GetNamespace()).Get(object.GetName()); err == nil {
-			if key, err = cache.MetaNamespaceKeyFunc(b); err == nil {
-				c.queue.Add(key)
-				return
-			}
-			log.Errorf("controller: parse metadata key failed: %v", err)
-			return
+	var b *v1alpha1.Bar
+	var err error
+	if b, err = c.barLister.Bars(object.GetNamespace()).Get(owner.Name); err != nil {
+		if !errors.IsNotFound(err) {
+			log.Errorf("controller: get Bar failed: %v", err)
 		}
+		return
+	}
 
-		log.Errorf("controller: get Bar failed: %v", err)
+	// the object belongs to a previous Bar with the same name
+	if b.UID != owner.UID {
+		log.Debugf("controller: ignore '%s/%s' owned by stale Bar uid %s", object.GetNamespace(), object.GetName(), owner.UID)
+		return
+	}
+
+	var key string
+	if key, err = cache.MetaNamespaceKeyFunc(b); err != nil {
+		log.Errorf("controller: parse metadata key failed: %v", err)
+		return
 	}
+	c.enqueue(key)
 }
 
-func addFooResourceHandlerFunc(queue workqueue.RateLimitingInterface) cache.ResourceEventHandlerFuncs {
+func (c *Controller) addFooResourceHandlerFunc() cache.ResourceEventHandlerFuncs {
 	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
-				queue.Add(key)
+				c.enqueue(key)
 			}
 		},
 		UpdateFunc: func(old, cur interface{}) {
+			// the periodic resync replays the same object
+			if !isResync(old, cur) && !barUpdatePredicate.allow(old, cur) {
+				return
+			}
 			if key, err := cache.MetaNamespaceKeyFunc(cur); err == nil {
-				queue.Add(key)
+				c.enqueue(key)
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
 			if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
-				queue.Add(key)
+				c.enqueue(key)
 			}
 		},
 	}
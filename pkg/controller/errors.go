@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+)
+
+// terminalError is an error of a reconcile which cannot succeed by retrying,
+// the Bar is not reconciled again until its generation changes.
+type terminalError struct {
+	reason string
+	err    error
+}
+
+func (e *terminalError) Error() string { return e.err.Error() }
+
+func (e *terminalError) Unwrap() error { return e.err }
+
+// deadlineError is the error of a reconcile which exceeded the reconcile
+// timeout, it is retried with backoff.
+type deadlineError struct {
+	err error
+}
+
+func (e *deadlineError) Error() string { return "reconcile deadline exceeded: " + e.err.Error() }
+
+func (e *deadlineError) Unwrap() error { return e.err }
+
+// withDeadline returns the error of a reconcile as a deadlineError if the
+// reconcile context exceeded its deadline.
+func withDeadline(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return &deadlineError{err: err}
+	}
+	return err
+}
+
+// newTerminalError returns a terminal error with the condition reason.
+func newTerminalError(reason string, err error) error {
+	return &terminalError{reason: reason, err: err}
+}
+
+// classifyError returns the error as a terminal error if it can never succeed
+// on retry, otherwise the error is returned unchanged.
+func classifyError(err error) error {
+	var terr *terminalError
+	var derr *deadlineError
+	switch {
+	case err == nil, errors.As(err, &terr), errors.As(err, &derr):
+		return err
+	case apierrors.IsForbidden(err):
+		return newTerminalError(v1alpha1.ReasonForbidden, err)
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return newTerminalError(v1alpha1.ReasonRejected, err)
+	}
+	return err
+}
+
+// errorReason returns the condition reason of a reconcile error and whether
+// the error is terminal.
+func errorReason(err error) (string, bool) {
+	var terr *terminalError
+	if errors.As(err, &terr) {
+		return terr.reason, true
+	}
+	var perr *panicError
+	if errors.As(err, &perr) {
+		return v1alpha1.ReasonPanic, false
+	}
+	var derr *deadlineError
+	if errors.As(err, &derr) {
+		return v1alpha1.ReasonReconcileTimeout, false
+	}
+	return v1alpha1.ReasonReconcileFailed, false
+}
+
+// isStalled returns true if the last reconcile of the current generation of
+// the Bar failed with a terminal error.
+func isStalled(bar *v1alpha1.Bar) bool {
+	cond := meta.FindStatusCondition(bar.Status.Conditions, v1alpha1.ConditionStalled)
+	return cond != nil && cond.Status == metav1.ConditionTrue && cond.ObservedGeneration == bar.Generation
+}
+
+// validateBar returns a terminal error if the spec of the Bar is invalid.
+func validateBar(bar *v1alpha1.Bar) error {
+	if bar.Spec == nil {
+		return newTerminalError(v1alpha1.ReasonInvalidSpec, fmt.Errorf("spec is required"))
+	}
+
+	var errs []string
+	if bar.Spec.Image == "" {
+		errs = append(errs, "spec.image is required")
+	}
+	if bar.Spec.Replicas < 0 {
+		errs = append(errs, fmt.Sprintf("spec.replicas must not be negative, got %d", bar.Spec.Replicas))
+	}
+	for _, msg := range validation.IsDNS1123Label(buildContainerName(bar)) {
+		errs = append(errs, "invalid container name: "+msg)
+	}
+	switch deletionPolicy(bar) {
+	case v1alpha1.DeletionPolicyDelete, v1alpha1.DeletionPolicyOrphan, v1alpha1.DeletionPolicyRetain:
+	default:
+		errs = append(errs, fmt.Sprintf("unsupported spec.deletionPolicy %q", bar.Spec.DeletionPolicy))
+	}
+
+	if len(errs) > 0 {
+		return newTerminalError(v1alpha1.ReasonInvalidSpec, fmt.Errorf("invalid spec: %s", strings.Join(errs, "; ")))
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
+)
+
+// unfinishedWorkUpdatePeriod is the interval the unfinished work metrics are
+// updated at, same as the queues of the workqueue package.
+const unfinishedWorkUpdatePeriod = 500 * time.Millisecond
+
+// fairQueue is a workqueue.Interface which hands out the keys round-robin
+// across namespaces, so a namespace with many queued Bars does not delay the
+// Bars of the other namespaces. It has the same semantics as workqueue.Type:
+// a key is never processed concurrently and a key added while it is being
+// processed is queued again once it is done.
+type fairQueue struct {
+	cond *sync.Cond
+
+	// maxPerNamespace caps the number of keys of a namespace processed
+	// concurrently, 0 means no cap.
+	maxPerNamespace int
+
+	// pending holds the queued keys of each namespace in FIFO order and ring
+	// holds the namespaces with queued keys in round-robin order, next is the
+	// index in ring of the namespace to serve next.
+	pending map[string][]interface{}
+	ring    []string
+	next    int
+	length  int
+
+	dirty      map[interface{}]struct{}
+	processing map[interface{}]struct{}
+	active     map[string]int
+
+	shuttingDown bool
+	drain        bool
+
+	depth        workqueue.GaugeMetric
+	adds         workqueue.CounterMetric
+	latency      workqueue.HistogramMetric
+	workDuration workqueue.HistogramMetric
+	// unfinishedWork and longestRunning are updated periodically from the
+	// start times of the keys being processed
+	unfinishedWork workqueue.SettableGaugeMetric
+	longestRunning workqueue.SettableGaugeMetric
+	addTimes       map[interface{}]time.Time
+	startTimes     map[interface{}]time.Time
+}
+
+var _ workqueue.Interface = &fairQueue{}
+
+func newFairQueue(name string, maxPerNamespace int) *fairQueue {
+	provider := metrics.WorkqueueMetricsProvider()
+	q := &fairQueue{
+		cond:            sync.NewCond(&sync.Mutex{}),
+		maxPerNamespace: maxPerNamespace,
+		pending:         map[string][]interface{}{},
+		dirty:           map[interface{}]struct{}{},
+		processing:      map[interface{}]struct{}{},
+		active:          map[string]int{},
+		depth:           provider.NewDepthMetric(name),
+		adds:            provider.NewAddsMetric(name),
+		latency:         provider.NewLatencyMetric(name),
+		workDuration:    provider.NewWorkDurationMetric(name),
+		unfinishedWork:  provider.NewUnfinishedWorkSecondsMetric(name),
+		longestRunning:  provider.NewLongestRunningProcessorSecondsMetric(name),
+		addTimes:        map[interface{}]time.Time{},
+		startTimes:      map[interface{}]time.Time{},
+	}
+	go q.updateUnfinishedWorkLoop()
+	return q
+}
+
+// updateUnfinishedWorkLoop updates the unfinished work metrics until the queue
+// is shutting down.
+func (q *fairQueue) updateUnfinishedWorkLoop() {
+	ticker := time.NewTicker(unfinishedWorkUpdatePeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !q.updateUnfinishedWork() {
+			return
+		}
+	}
+}
+
+// updateUnfinishedWork sets the unfinished work metrics from the keys being
+// processed, it returns false once the queue is shutting down.
+func (q *fairQueue) updateUnfinishedWork() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return false
+	}
+
+	var total, longest float64
+	now := time.Now()
+	for _, t := range q.startTimes {
+		d := now.Sub(t).Seconds()
+		total += d
+		if d > longest {
+			longest = d
+		}
+	}
+	q.unfinishedWork.Set(total)
+	q.longestRunning.Set(longest)
+	return true
+}
+
+// itemNamespace returns the namespace of a queued key.
+func itemNamespace(item interface{}) string {
+	key, ok := item.(string)
+	if !ok {
+		return ""
+	}
+	namespace, _, _ := cache.SplitMetaNamespaceKey(key)
+	return namespace
+}
+
+// Add marks item as needing processing.
+func (q *fairQueue) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+
+	q.adds.Inc()
+	if _, ok := q.addTimes[item]; !ok {
+		q.addTimes[item] = time.Now()
+	}
+
+	q.dirty[item] = struct{}{}
+	if _, ok := q.processing[item]; ok {
+		return
+	}
+	q.push(item)
+}
+
+// push appends the item to the queue of its namespace, the lock must be held.
+func (q *fairQueue) push(item interface{}) {
+	namespace := itemNamespace(item)
+	if len(q.pending[namespace]) == 0 {
+		q.ring = append(q.ring, namespace)
+	}
+	q.pending[namespace] = append(q.pending[namespace], item)
+	q.length++
+	q.depth.Inc()
+	q.cond.Broadcast()
+}
+
+// pop removes and returns the first key of the next namespace below its
+// concurrency cap, the lock must be held.
+func (q *fairQueue) pop() (interface{}, bool) {
+	for n := 0; n < len(q.ring); n++ {
+		i := (q.next + n) % len(q.ring)
+		namespace := q.ring[i]
+		if q.maxPerNamespace > 0 && q.active[namespace] >= q.maxPerNamespace {
+			continue
+		}
+
+		items := q.pending[namespace]
+		item := items[0]
+		items[0] = nil
+		if items = items[1:]; len(items) > 0 {
+			q.pending[namespace] = items
+			q.next = i + 1
+		} else {
+			delete(q.pending, namespace)
+			q.ring = append(q.ring[:i], q.ring[i+1:]...)
+			q.next = i
+		}
+		if q.next >= len(q.ring) {
+			q.next = 0
+		}
+		q.length--
+		q.depth.Dec()
+		q.active[namespace]++
+		return item, true
+	}
+	return nil, false
+}
+
+// longestWait returns how long the oldest queued key has been waiting.
+func (q *fairQueue) longestWait() time.Duration {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	var longest time.Duration
+	now := time.Now()
+	for _, items := range q.pending {
+		// the keys of a namespace are queued in FIFO order
+		if t, ok := q.addTimes[items[0]]; ok && now.Sub(t) > longest {
+			longest = now.Sub(t)
+		}
+	}
+	return longest
+}
+
+// Len returns the number of queued keys.
+func (q *fairQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.length
+}
+
+// Get blocks until it can return an item to be processed. If shutdown = true,
+// the caller should end their goroutine. You must call Done with item when you
+// have finished processing it.
+func (q *fairQueue) Get() (item interface{}, shutdown bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for {
+		var ok bool
+		if item, ok = q.pop(); ok {
+			break
+		}
+		if q.shuttingDown {
+			return nil, true
+		}
+		q.cond.Wait()
+	}
+
+	now := time.Now()
+	if t, ok := q.addTimes[item]; ok {
+		q.latency.Observe(now.Sub(t).Seconds())
+		delete(q.addTimes, item)
+	}
+	q.startTimes[item] = now
+
+	q.processing[item] = struct{}{}
+	delete(q.dirty, item)
+	return item, false
+}
+
+// Done marks item as done processing, and if it has been marked as dirty again
+// while it was being processed, it will be re-added to the queue for
+// re-processing.
+func (q *fairQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	if t, ok := q.startTimes[item]; ok {
+		q.workDuration.Observe(time.Since(t).Seconds())
+		delete(q.startTimes, item)
+	}
+
+	if _, ok := q.processing[item]; !ok {
+		return
+	}
+	delete(q.processing, item)
+	namespace := itemNamespace(item)
+	if q.active[namespace]--; q.active[namespace] <= 0 {
+		delete(q.active, namespace)
+	}
+
+	if _, ok := q.dirty[item]; ok {
+		q.push(item)
+		return
+	}
+	// a namespace may be below its cap again or the queue may be drained
+	q.cond.Broadcast()
+}
+
+// ShutDown will cause q to ignore all new items added to it and
+// immediately instruct the worker goroutines to exit.
+func (q *fairQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.drain = false
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShutDownWithDrain will cause q to ignore all new items added to it and
+// waits until the workers have called Done on every item being processed.
+func (q *fairQueue) ShutDownWithDrain() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.drain = true
+	q.shuttingDown = true
+	q.cond.Broadcast()
+	for len(q.processing) > 0 && q.drain {
+		q.cond.Wait()
+	}
+}
+
+func (q *fairQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
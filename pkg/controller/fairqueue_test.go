@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fairQueueStep is a step of a fair queue test, only one field is set.
+type fairQueueStep struct {
+	// add adds the key
+	add string
+	// get gets a key, which must be this one
+	get string
+	// done marks the key as done
+	done string
+	// idle checks that no key can be handed out
+	idle bool
+}
+
+func TestFairQueue(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxPerNamespace int
+		steps           []fairQueueStep
+	}{
+		{
+			name: "dedupe queued key",
+			steps: []fairQueueStep{
+				{add: "a/1"}, {add: "a/1"},
+				{get: "a/1"}, {idle: true},
+			},
+		},
+		{
+			name: "re-add while processing",
+			steps: []fairQueueStep{
+				{add: "a/1"}, {get: "a/1"},
+				{add: "a/1"}, {add: "a/1"}, {idle: true},
+				{done: "a/1"}, {get: "a/1"}, {idle: true},
+				{done: "a/1"}, {idle: true},
+			},
+		},
+		{
+			name: "round-robin across namespaces",
+			steps: []fairQueueStep{
+				{add: "a/1"}, {add: "a/2"}, {add: "a/3"}, {add: "b/1"}, {add: "b/2"}, {add: "c/1"},
+				{get: "a/1"}, {get: "b/1"}, {get: "c/1"}, {get: "a/2"}, {get: "b/2"}, {get: "a/3"},
+				{idle: true},
+			},
+		},
+		{
+			name: "namespace joining the round-robin",
+			steps: []fairQueueStep{
+				{add: "a/1"}, {add: "a/2"}, {add: "a/3"},
+				{get: "a/1"}, {add: "b/1"},
+				{get: "a/2"}, {get: "b/1"}, {get: "a/3"},
+			},
+		},
+		{
+			name:            "namespace cap",
+			maxPerNamespace: 1,
+			steps: []fairQueueStep{
+				{add: "a/1"}, {add: "a/2"}, {add: "b/1"}, {add: "b/2"},
+				{get: "a/1"}, {get: "b/1"}, {idle: true},
+				{done: "b/1"}, {get: "b/2"}, {idle: true},
+				{done: "a/1"}, {get: "a/2"},
+			},
+		},
+		{
+			name:            "namespace cap with re-added key",
+			maxPerNamespace: 2,
+			steps: []fairQueueStep{
+				{add: "a/1"}, {add: "a/2"}, {add: "a/3"},
+				{get: "a/1"}, {get: "a/2"}, {add: "a/1"}, {idle: true},
+				{done: "a/2"}, {get: "a/3"}, {idle: true},
+				{done: "a/1"}, {get: "a/1"}, {idle: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newFairQueue("", tt.maxPerNamespace)
+			defer q.ShutDown()
+
+			for i, step := range tt.steps {
+				switch {
+				case step.add != "":
+					q.Add(step.add)
+				case step.get != "":
+					item, shutdown := getWithin(t, q, time.Second)
+					if shutdown || item != step.get {
+						t.Fatalf("step %d: got %v (shutdown %v), want %s", i, item, shutdown, step.get)
+					}
+				case step.done != "":
+					q.Done(step.done)
+				case step.idle:
+					q.cond.L.Lock()
+					item, ok := q.pop()
+					q.cond.L.Unlock()
+					if ok {
+						t.Fatalf("step %d: got %v, want no key", i, item)
+					}
+				}
+			}
+		})
+	}
+}
+
+func getWithin(t *testing.T, q workqueue.Interface, timeout time.Duration) (interface{}, bool) {
+	type result struct {
+		item     interface{}
+		shutdown bool
+	}
+	ch := make(chan result, 1)
+	go func() {
+		item, shutdown := q.Get()
+		ch <- result{item, shutdown}
+	}()
+	select {
+	case r := <-ch:
+		return r.item, r.shutdown
+	case <-time.After(timeout):
+		t.Fatalf("Get blocked for %s", timeout)
+		return nil, false
+	}
+}
+
+func TestFairQueueShutDown(t *testing.T) {
+	q := newFairQueue("", 0)
+	q.Add("a/1")
+	q.ShutDown()
+
+	q.Add("b/1")
+	if item, shutdown := getWithin(t, q, time.Second); shutdown || item != "a/1" {
+		t.Fatalf("got %v (shutdown %v), want the key queued before the shutdown", item, shutdown)
+	}
+	if _, shutdown := getWithin(t, q, time.Second); !shutdown {
+		t.Fatalf("got a key added after the shutdown")
+	}
+}
+
+func TestFairQueueShutDownUnblocksGet(t *testing.T) {
+	q := newFairQueue("", 0)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		q.ShutDown()
+	}()
+	if _, shutdown := getWithin(t, q, time.Second); !shutdown {
+		t.Fatalf("Get returned a key from an empty queue")
+	}
+}
+
+func TestFairQueueShutDownWithDrain(t *testing.T) {
+	q := newFairQueue("", 0)
+	q.Add("a/1")
+	item, _ := q.Get()
+
+	drained := make(chan struct{})
+	go func() {
+		q.ShutDownWithDrain()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		t.Fatalf("ShutDownWithDrain returned while a key was processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !q.ShuttingDown() {
+		t.Fatalf("queue not shutting down")
+	}
+
+	q.Done(item)
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatalf("ShutDownWithDrain did not return once the keys were done")
+	}
+}
+
+func TestFairQueueUnfinishedWork(t *testing.T) {
+	q := newFairQueue("unfinished", 0)
+	defer q.ShutDown()
+	q.Add("a/1")
+	q.Add("b/1")
+	a, _ := q.Get()
+	b, _ := q.Get()
+
+	q.cond.L.Lock()
+	q.startTimes[a] = time.Now().Add(-3 * time.Second)
+	q.startTimes[b] = time.Now().Add(-time.Second)
+	q.cond.L.Unlock()
+
+	q.updateUnfinishedWork()
+	if got := testutil.ToFloat64(q.unfinishedWork.(prometheus.Gauge)); got < 4 || got > 5 {
+		t.Errorf("unfinished work = %.1fs, want 4s", got)
+	}
+	if got := testutil.ToFloat64(q.longestRunning.(prometheus.Gauge)); got < 3 || got > 4 {
+		t.Errorf("longest running processor = %.1fs, want 3s", got)
+	}
+
+	q.Done(a)
+	q.Done(b)
+	q.updateUnfinishedWork()
+	if got := testutil.ToFloat64(q.unfinishedWork.(prometheus.Gauge)); got != 0 {
+		t.Errorf("unfinished work = %.1fs once done, want 0", got)
+	}
+}
+
+// BenchmarkNoisyTenant measures how long the Bars of quiet namespaces wait
+// while a noisy namespace has queued hundreds of Bars, with the FIFO queue
+// and with the fair queue.
+func BenchmarkNoisyTenant(b *testing.B) {
+	b.Run("fifo", func(b *testing.B) {
+		benchmarkNoisyTenant(b, func() workqueue.Interface { return workqueue.New() })
+	})
+
+	b.Run("fair", func(b *testing.B) {
+		benchmarkNoisyTenant(b, func() workqueue.Interface { return newFairQueue("", 0) })
+	})
+
+	b.Run("fair-capped", func(b *testing.B) {
+		benchmarkNoisyTenant(b, func() workqueue.Interface { return newFairQueue("", 2) })
+	})
+}
+
+func benchmarkNoisyTenant(b *testing.B, newQueue func() workqueue.Interface) {
+	const noisy, quiet, workers = 500, 20, 4
+	const work = 50 * time.Microsecond
+
+	var worst, total time.Duration
+	for i := 0; i < b.N; i++ {
+		q := newQueue()
+		for j := 0; j < noisy; j++ {
+			q.Add(fmt.Sprintf("noisy/bar-%d", j))
+		}
+
+		added := time.Now()
+		for j := 0; j < quiet; j++ {
+			q.Add(fmt.Sprintf("quiet-%d/bar", j))
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		var latencies []time.Duration
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					item, quit := q.Get()
+					if quit {
+						return
+					}
+					time.Sleep(work)
+					if itemNamespace(item) != "noisy" {
+						mu.Lock()
+						latencies = append(latencies, time.Since(added))
+						mu.Unlock()
+					}
+					q.Done(item)
+					if q.Len() == 0 {
+						q.ShutDown()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(latencies) != quiet {
+			b.Fatalf("expected %d quiet Bars processed, got %d", quiet, len(latencies))
+		}
+		for _, d := range latencies {
+			total += d
+			if d > worst {
+				worst = d
+			}
+		}
+	}
+	b.ReportMetric(float64(total.Microseconds())/float64(b.N*quiet), "quiet-avg-us")
+	b.ReportMetric(float64(worst.Microseconds()), "quiet-max-us")
+}
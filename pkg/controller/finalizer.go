@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+// finalizePollInterval is the interval to check again whether the pods of a
+// deleted Bar have terminated.
+const finalizePollInterval = 2 * time.Second
+
+func hasFinalizer(bar *v1alpha1.Bar) bool {
+	for _, f := range bar.Finalizers {
+		if f == v1alpha1.BarFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func deletionPolicy(bar *v1alpha1.Bar) string {
+	if bar.Spec == nil || bar.Spec.DeletionPolicy == "" {
+		return v1alpha1.DeletionPolicyDelete
+	}
+	return bar.Spec.DeletionPolicy
+}
+
+// addFinalizer adds the Bar finalizer and returns the updated Bar.
+func (c *Controller) addFinalizer(ctx context.Context, bar *v1alpha1.Bar) (*v1alpha1.Bar, error) {
+	finalizers := append(append([]string{}, bar.Finalizers...), v1alpha1.BarFinalizer)
+	return c.patchFinalizers(ctx, bar, finalizers)
+}
+
+// removeFinalizer removes the Bar finalizer, the API server deletes the Bar
+// once no finalizer is left.
+func (c *Controller) removeFinalizer(ctx context.Context, bar *v1alpha1.Bar) error {
+	var finalizers []string
+	for _, f := range bar.Finalizers {
+		if f != v1alpha1.BarFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	_, err := c.patchFinalizers(ctx, bar, finalizers)
+	return err
+}
+
+func (c *Controller) patchFinalizers(ctx context.Context, bar *v1alpha1.Bar, finalizers []string) (*v1alpha1.Bar, error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": bar.ResourceVersion,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.fooClient.FooV1alpha1().Bars(bar.Namespace).Patch(ctx, bar.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
+
+// finalize cleans up the children of a deleted Bar according to its deletion
+// policy and removes the finalizer when it is done.
+func (c *Controller) finalize(ctx context.Context, bar *v1alpha1.Bar) (Result, error) {
+	if !hasFinalizer(bar) {
+		return Result{}, nil
+	}
+
+	deploys, err := c.ownedDeployments(bar)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var pending bool
+	for _, deploy := range deploys {
+		var done bool
+		switch policy := deletionPolicy(bar); policy {
+		case v1alpha1.DeletionPolicyOrphan, v1alpha1.DeletionPolicyRetain:
+			done, err = true, c.releaseDeployment(ctx, deploy, bar, policy == v1alpha1.DeletionPolicyOrphan)
+		default:
+			done, err = c.deleteDeployment(ctx, deploy, bar)
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		pending = pending || !done
+	}
+	if pending {
+		return Result{RequeueAfter: finalizePollInterval}, nil
+	}
+
+	if err = c.removeFinalizer(ctx, bar); err != nil && !errors.IsNotFound(err) {
+		return Result{}, err
+	}
+	log.FromContext(ctx).Info("finalizer removed")
+	return Result{}, nil
+}
+
+// releaseDeployment strips the owner reference of the Bar from the Deployment
+// so it is not garbage collected. If orphan is true, the managed-by label is
+// removed too and the Deployment is not adopted by a new Bar anymore.
+func (c *Controller) releaseDeployment(ctx context.Context, deploy *appsv1.Deployment, bar *v1alpha1.Bar, orphan bool) error {
+	ownerRefs := []metav1.OwnerReference{}
+	for _, ref := range deploy.OwnerReferences {
+		if ref.UID != bar.UID {
+			ownerRefs = append(ownerRefs, ref)
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"ownerReferences": ownerRefs,
+		"resourceVersion": deploy.ResourceVersion,
+	}
+	if orphan {
+		metadata["labels"] = map[string]interface{}{labelManagedBy: nil}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return err
+	}
+	if _, err = c.kubeClient.AppsV1().Deployments(deploy.Namespace).Patch(ctx, deploy.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		log.FromContext(ctx).Error("release deployment failed", "deployment", deploy.Name, "error", err)
+		return err
+	}
+	log.FromContext(ctx).Info("deployment released", "deployment", deploy.Name, "orphan", orphan)
+	return nil
+}
+
+// deleteDeployment scales the Deployment down to zero, waits for its pods to
+// terminate and then deletes it. It returns true once the Deployment is gone.
+func (c *Controller) deleteDeployment(ctx context.Context, deploy *appsv1.Deployment, bar *v1alpha1.Bar) (bool, error) {
+	if deploy.Spec.Replicas == nil || *deploy.Spec.Replicas != 0 {
+		patch := []byte(`{"spec":{"replicas":0}}`)
+		if _, err := c.kubeClient.AppsV1().Deployments(deploy.Namespace).Patch(ctx, deploy.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			log.FromContext(ctx).Error("scale down deployment failed", "deployment", deploy.Name, "error", err)
+			return false, err
+		}
+		c.recorder.Eventf(bar, corev1.EventTypeNormal, "ScalingDown", "Scaling down deployment %q before deletion", deploy.Name)
+		return false, nil
+	}
+
+	pods, err := c.kubeClient.CoreV1().Pods(deploy.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(buildLabels(bar)).String(),
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(pods.Items) > 0 {
+		log.FromContext(ctx).Debug("waiting for pods to terminate", "deployment", deploy.Name, "pods", len(pods.Items))
+		return false, nil
+	}
+
+	err = c.kubeClient.AppsV1().Deployments(deploy.Namespace).Delete(ctx, deploy.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &deploy.UID},
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		log.FromContext(ctx).Error("delete deployment failed", "deployment", deploy.Name, "error", err)
+		return false, err
+	}
+	log.FromContext(ctx).Info("deployment deleted", "deployment", deploy.Name)
+	return true, nil
+}
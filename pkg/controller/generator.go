@@ -8,6 +8,11 @@ import (
 	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
 )
 
+const (
+	labelManagedBy = "app.kubernetes.io/managed-by"
+	managedBy      = "xcontroller"
+)
+
 func buildDeployment(bar *v1alpha1.Bar) *appsv1.Deployment {
 	labels := buildLabels(bar)
 	return &appsv1.Deployment{
@@ -28,7 +33,7 @@ func buildDeployment(bar *v1alpha1.Bar) *appsv1.Deployment {
 				},
 				Spec: corev1.PodSpec{Containers: []corev1.Container{{
 					Name:  buildContainerName(bar),
-					Image: bar.Spec.Image,
+					Image: desiredImage(bar),
 				}}},
 			},
 		},
@@ -37,9 +42,9 @@ func buildDeployment(bar *v1alpha1.Bar) *appsv1.Deployment {
 
 func buildLabels(bar *v1alpha1.Bar) map[string]string {
 	return map[string]string{
-		"app.kubernetes.io/name":       bar.Name,
-		"app.kubernetes.io/managed-by": "xcontroller",
-		"app.kubernetes.io/instance":   bar.Name,
+		"app.kubernetes.io/name":     bar.Name,
+		labelManagedBy:               managedBy,
+		"app.kubernetes.io/instance": bar.Name,
 	}
 }
 
@@ -49,3 +54,13 @@ func buildContainerName(bar *v1alpha1.Bar) string {
 	}
 	return bar.Name
 }
+
+// desiredImage returns the image the Deployment of the Bar should run. After an
+// automatic rollback the last ready image is kept until the spec requests
+// another image.
+func desiredImage(bar *v1alpha1.Bar) string {
+	if isRolledBack(bar) && bar.Status.CurrentImage != "" {
+		return bar.Status.CurrentImage
+	}
+	return bar.Spec.Image
+}
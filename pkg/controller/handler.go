@@ -1,62 +1,94 @@
 package controller
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 
-	"github.com/google/go-cmp/cmp"
+	jsonpatch "github.com/evanphx/json-patch"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/util/retry"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
 	"github.com/vietanhduong/xcontroller/pkg/util/log"
 )
 
-func (c *Controller) reconcile(bar *v1alpha1.Bar) (err error) {
-	if bar.Status.Ready != fmt.Sprintf("%d/%d", bar.Status.ReadyReplicas, bar.Spec.Replicas) {
-		err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-			var tmp *v1alpha1.Bar
-			if tmp, err = c.barLister.Bars(bar.Namespace).Get(bar.Name); err != nil {
-				return err
-			}
-			tmp.Status.Ready = fmt.Sprintf("%d/%d", bar.Status.ReadyReplicas, bar.Spec.Replicas)
-			if tmp, err = c.fooClient.FooV1alpha1().Bars(bar.Namespace).UpdateStatus(c.ctx, tmp, metav1.UpdateOptions{}); err != nil {
-				return err
-			}
-			tmp.DeepCopyInto(bar)
-			return nil
-		})
+// reconcile drives the Deployment of the Bar to its desired state, then
+// computes the whole status of the Bar and patches it at most once.
+func (c *Controller) reconcile(ctx context.Context, bar *v1alpha1.Bar) (Result, error) {
+	deploy, err := c.handleDeployment(ctx, bar)
+	if err != nil {
+		return Result{}, err
+	}
 
-		// return current reconcile, wait to the next reconcile
-		return err
+	desired := bar.DeepCopy()
+	var res Result
+	if res, err = c.computeStatus(ctx, deploy, desired); err != nil {
+		return requeueOnConflict(ctx, err)
 	}
 
-	var deploy *appsv1.Deployment
-	// if the deployment is nil, that means the deployment has been updated, we will wait for the next reconcile
-	if deploy, err = c.handleDeployment(bar); err != nil || deploy == nil {
-		return err
+	var patched bool
+	if patched, err = c.patchStatus(ctx, bar, desired); err != nil {
+		if !errors.IsConflict(err) {
+			log.FromContext(ctx).Error("patch bar status failed", "error", err)
+		}
+		return requeueOnConflict(ctx, err)
+	}
+	if patched {
+		c.recorder.Eventf(bar, "Normal", "Updated", "Bar has been updated!")
 	}
+	return res, nil
+}
 
-	return c.onReconcileSuccess(deploy, bar)
+// requeueOnConflict requeues the Bar without reporting a failure if err is a
+// Conflict, the cached Bar is stale and is retried once the cache caught up.
+func requeueOnConflict(ctx context.Context, err error) (Result, error) {
+	if errors.IsConflict(err) {
+		log.FromContext(ctx).Debug("bar status conflict, requeuing", "error", err)
+		return Result{Requeue: true}, nil
+	}
+	return Result{}, err
 }
 
-func (c *Controller) handleDeployment(bar *v1alpha1.Bar) (deploy *appsv1.Deployment, err error) {
+// handleDeployment creates or updates the Deployment of the Bar and returns
+// its latest known state.
+func (c *Controller) handleDeployment(ctx context.Context, bar *v1alpha1.Bar) (deploy *appsv1.Deployment, err error) {
 	if deploy, err = c.deployLister.Deployments(bar.Namespace).Get(bar.Name); err != nil {
 		if errors.IsNotFound(err) {
-			_, err = c.kubeClient.AppsV1().Deployments(bar.Namespace).Create(c.ctx, buildDeployment(bar), metav1.CreateOptions{})
-			if err != nil {
-				log.Errorf("reconcile bar %s/%s: create deployment failed: %v", bar.Namespace, bar.Name, err)
+			if deploy, err = c.kubeClient.AppsV1().Deployments(bar.Namespace).Create(ctx, buildDeployment(bar), metav1.CreateOptions{}); err != nil {
+				log.FromContext(ctx).Error("create deployment failed", "error", err)
+				return nil, err
 			}
-			log.Debugf("reconcile bar %s/%s: create deployment successful", bar.Namespace, bar.Name)
-			return nil, err
+			log.FromContext(ctx).Debug("create deployment successful")
+			return deploy, nil
 		}
-		log.Errorf("reconcile bar %s/%s: get deployment failed: %v", bar.Namespace, bar.Name, err)
+		log.FromContext(ctx).Error("get deployment failed", "error", err)
+		return nil, err
+	}
+
+	if ref := metav1.GetControllerOf(deploy); ref != nil && ref.UID != bar.UID {
+		// the Deployment is still controlled by a previous Bar with the same name
+		err = fmt.Errorf("deployment %s/%s is controlled by %s %s (uid %s)", deploy.Namespace, deploy.Name, ref.Kind, ref.Name, ref.UID)
+		log.FromContext(ctx).Error("deployment controlled by another object", "error", err)
 		return nil, err
 	}
 
+	// deploy is a deep copy of the cached Deployment and may be modified
 	var changed bool
+	if metav1.GetControllerOf(deploy) == nil && deploy.Labels[labelManagedBy] == managedBy {
+		// adopt the Deployment retained by a previous Bar with the same name
+		deploy.OwnerReferences = append(deploy.OwnerReferences, *metav1.NewControllerRef(bar, v1alpha1.SchemeGroupVersion.WithKind("Bar")))
+		changed = true
+	}
+
 	if *deploy.Spec.Replicas != bar.Spec.Replicas {
 		deploy.Spec.Replicas = &bar.Spec.Replicas
 		changed = true
@@ -65,52 +97,185 @@ func (c *Controller) handleDeployment(bar *v1alpha1.Bar) (deploy *appsv1.Deploym
 	handleContainer(deploy, bar, &changed)
 
 	if changed {
-		if _, err = c.kubeClient.AppsV1().Deployments(bar.Namespace).Update(c.ctx, deploy, metav1.UpdateOptions{}); err != nil {
-			log.Errorf("reconcile bar %s/%s: update deployment failed: %v", bar.Namespace, bar.Name, err)
+		if deploy, err = c.kubeClient.AppsV1().Deployments(bar.Namespace).Update(ctx, deploy, metav1.UpdateOptions{}); err != nil {
+			log.FromContext(ctx).Error("update deployment failed", "error", err)
+			return nil, err
 		}
-		return nil, err
+		log.FromContext(ctx).Debug("update deployment successful")
+		return deploy, nil
 	}
-	log.Debugf("reconcile bar %s/%s: handle deployment completed with no changed", bar.Namespace, bar.Name)
+	log.FromContext(ctx).Debug("deployment is up to date")
 	return deploy, nil
 }
 
-func (c *Controller) onReconcileFailed(bar *v1alpha1.Bar, err error) {
+// degradedFailures is the number of consecutive failed reconciles after which
+// a Bar is reported Degraded, transient errors such as conflicts usually
+// succeed on the next retry.
+const degradedFailures = 3
+
+// onReconcileFailed reports the failed reconcile in the status of the Bar. The
+// Bar is only reported Degraded if the error is terminal or it keeps failing.
+func (c *Controller) onReconcileFailed(ctx context.Context, bar *v1alpha1.Bar, err error) {
 	var msg = err.Error()
-	e := retry.RetryOnConflict(retry.DefaultRetry, func() (err error) {
-		var tmp *v1alpha1.Bar
-		if tmp, err = c.barLister.Bars(bar.Namespace).Get(bar.Name); err != nil {
-			return err
+	reason, terminal := errorReason(err)
+	key, _ := cache.MetaNamespaceKeyFunc(bar)
+	// the current failure is not counted yet
+	persistent := terminal || c.failures.get(key)+1 >= degradedFailures
+	if terminal {
+		c.recorder.Eventf(bar, corev1.EventTypeWarning, reason, msg)
+	}
+
+	desired := bar.DeepCopy()
+	desired.Status.ObservedGeneration = desired.Generation
+	desired.Status.Success = false
+	desired.Status.Message = msg
+	setCondition(desired, v1alpha1.ConditionReconciled, metav1.ConditionFalse, reason, msg)
+	if persistent {
+		setCondition(desired, v1alpha1.ConditionDegraded, metav1.ConditionTrue, reason, msg)
+	}
+	if terminal {
+		setCondition(desired, v1alpha1.ConditionStalled, metav1.ConditionTrue, reason, msg)
+	} else {
+		setCondition(desired, v1alpha1.ConditionStalled, metav1.ConditionFalse, reason, "")
+	}
+	setCondition(desired, v1alpha1.ConditionQuarantined, metav1.ConditionFalse, reason, "")
+
+	if _, e := c.patchStatus(ctx, bar, desired); e != nil {
+		log.FromContext(ctx).Error("patch bar status of the failed reconcile failed", "error", e)
+	}
+}
+
+// computeStatus sets the status of the Bar from the observed Deployment and
+// rolls the Deployment back if its rollout failed.
+func (c *Controller) computeStatus(ctx context.Context, deploy *appsv1.Deployment, bar *v1alpha1.Bar) (res Result, err error) {
+	metrics.BarDesiredReplicas.WithLabelValues(bar.Namespace, bar.Name).Set(float64(bar.Spec.Replicas))
+	metrics.BarReadyReplicas.WithLabelValues(bar.Namespace, bar.Name).Set(float64(deploy.Status.ReadyReplicas))
+
+	state, msg := getRolloutState(deploy)
+	if reason, failed := c.shouldRollback(ctx, deploy, bar, state); failed {
+		if err = c.rollback(ctx, deploy, bar, reason); err != nil {
+			return Result{}, err
 		}
+		state, msg = rolloutProgressing, fmt.Sprintf("Rolling back to image %q", bar.Status.CurrentImage)
+	}
 
-		tmp.Status.Success = false
-		tmp.Status.Message = msg
-		_, err = c.fooClient.FooV1alpha1().Bars(bar.Namespace).UpdateStatus(c.ctx, tmp, metav1.UpdateOptions{})
-		return err
-	})
+	bar.Status.ReadyReplicas = deploy.Status.ReadyReplicas
+	bar.Status.Ready = fmt.Sprintf("%d/%d", bar.Status.ReadyReplicas, bar.Spec.Replicas)
+	bar.Status.UpdatedReplicas = deploy.Status.UpdatedReplicas
+	bar.Status.AvailableReplicas = deploy.Status.AvailableReplicas
+	bar.Status.UnavailableReplicas = deploy.Status.UnavailableReplicas
+	bar.Status.DesiredImage = bar.Spec.Image
+	if state == rolloutComplete {
+		bar.Status.CurrentImage = deploymentImage(deploy, bar)
+	}
 
-	if e != nil {
-		log.Errorf("reconcile bar %/%s (on failed): retry ended with error: %v", e)
+	if state == rolloutFailed && !isRolledBack(bar) && !meta.IsStatusConditionTrue(bar.Status.Conditions, v1alpha1.ConditionDegraded) {
+		c.recorder.Eventf(bar, corev1.EventTypeWarning, v1alpha1.ReasonProgressDeadlineExceeded, msg)
 	}
+
+	bar.Status.ObservedGeneration = bar.Generation
+	bar.Status.Selector = labels.SelectorFromSet(buildLabels(bar)).String()
+	bar.Status.Success = state == rolloutComplete && !isRolledBack(bar)
+	bar.Status.Message = msg
+	if isRolledBack(bar) && msg == "" {
+		bar.Status.Message = fmt.Sprintf("Rollout of image %q failed, rolled back to image %q", bar.Status.RolledBackImage, bar.Status.CurrentImage)
+	}
+	updateConditions(deploy, bar, state, msg)
+
+	// pods crash looping do not change the Deployment, poll the rollout until
+	// it is complete or has failed
+	if state == rolloutProgressing {
+		res.RequeueAfter = rolloutPollInterval
+	}
+	return res, nil
 }
 
-func (c *Controller) onReconcileSuccess(deploy *appsv1.Deployment, bar *v1alpha1.Bar) (err error) {
-	cpStatus := bar.Status.DeepCopy()
-	if deploy.Status.ReadyReplicas != bar.Status.ReadyReplicas {
-		bar.Status.ReadyReplicas = deploy.Status.ReadyReplicas
-		bar.Status.Ready = fmt.Sprintf("%d/%d", bar.Status.ReadyReplicas, bar.Spec.Replicas)
+// patchStatus sends the difference between the status of the Bar and the
+// desired status as a JSON merge patch to the status subresource. The patch
+// carries the resourceVersion of the Bar, so a difference computed from a
+// stale cache fails with a Conflict instead of overwriting a newer status.
+// Nothing is sent if the status did not change. After a patch, desired holds
+// the new resourceVersion of the Bar.
+func (c *Controller) patchStatus(ctx context.Context, bar, desired *v1alpha1.Bar) (bool, error) {
+	key, _ := cache.MetaNamespaceKeyFunc(bar)
+	changed := !equality.Semantic.DeepEqual(bar.Status, desired.Status)
+	// the cache has not observed the last status written yet, the desired
+	// status may equal the cached one and still differ from the stored one
+	written, ok := c.statusVersions.Load(key)
+	if !changed && (!ok || written == bar.ResourceVersion) {
+		return false, nil
+	}
+
+	oldData, err := json.Marshal(map[string]interface{}{"status": bar.Status})
+	if err != nil {
+		return false, err
 	}
+	newData, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": desired.ResourceVersion},
+		"status":   desired.Status,
+	})
+	if err != nil {
+		return false, err
+	}
+	patch, err := jsonpatch.CreateMergePatch(oldData, newData)
+	if err != nil {
+		return false, err
+	}
+
+	patched, err := c.fooClient.FooV1alpha1().Bars(bar.Namespace).Patch(ctx, bar.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		return false, err
+	}
+	c.statusVersions.Store(key, patched.ResourceVersion)
+	desired.ResourceVersion = patched.ResourceVersion
+	return changed, nil
+}
 
-	bar.Status.Success = true
-	bar.Status.Message = ""
+// updateConditions sets the conditions of the Bar from the observed Deployment
+// after a successful reconcile.
+func updateConditions(deploy *appsv1.Deployment, bar *v1alpha1.Bar, state rolloutState, msg string) {
+	setCondition(bar, v1alpha1.ConditionReconciled, metav1.ConditionTrue, v1alpha1.ReasonReconcileSucceeded, "")
+	setCondition(bar, v1alpha1.ConditionStalled, metav1.ConditionFalse, v1alpha1.ReasonAsExpected, "")
+	setCondition(bar, v1alpha1.ConditionQuarantined, metav1.ConditionFalse, v1alpha1.ReasonAsExpected, "")
 
-	if !cmp.Equal(&cpStatus, bar.Status) {
-		if _, err = c.fooClient.FooV1alpha1().Bars(bar.Namespace).UpdateStatus(c.ctx, bar, metav1.UpdateOptions{}); err != nil {
-			log.Errorf("reconcile bar %s/%s: update bar status failed: %v", bar.Namespace, bar.Name, err)
-			return err
+	ready := fmt.Sprintf("%d/%d replicas are ready", deploy.Status.ReadyReplicas, bar.Spec.Replicas)
+	if deploy.Status.ReadyReplicas >= bar.Spec.Replicas {
+		setCondition(bar, v1alpha1.ConditionReady, metav1.ConditionTrue, v1alpha1.ReasonReplicasReady, ready)
+	} else {
+		setCondition(bar, v1alpha1.ConditionReady, metav1.ConditionFalse, v1alpha1.ReasonReplicasNotReady, ready)
+	}
+
+	switch {
+	case isRolledBack(bar):
+		if state == rolloutProgressing {
+			setCondition(bar, v1alpha1.ConditionProgressing, metav1.ConditionTrue, v1alpha1.ReasonRolloutInProgress, msg)
+		} else {
+			setCondition(bar, v1alpha1.ConditionProgressing, metav1.ConditionFalse, v1alpha1.ReasonRolledBack, msg)
 		}
-		c.recorder.Eventf(bar, "Normal", "Updated", "Bar has been updated!")
+		setCondition(bar, v1alpha1.ConditionDegraded, metav1.ConditionTrue, v1alpha1.ReasonRolledBack,
+			fmt.Sprintf("Rollout of image %q failed, rolled back to image %q", bar.Status.RolledBackImage, bar.Status.CurrentImage))
+	case state == rolloutProgressing:
+		setCondition(bar, v1alpha1.ConditionProgressing, metav1.ConditionTrue, v1alpha1.ReasonRolloutInProgress, msg)
+		setCondition(bar, v1alpha1.ConditionDegraded, metav1.ConditionFalse, v1alpha1.ReasonAsExpected, "")
+	case state == rolloutFailed:
+		setCondition(bar, v1alpha1.ConditionProgressing, metav1.ConditionFalse, v1alpha1.ReasonProgressDeadlineExceeded, msg)
+		setCondition(bar, v1alpha1.ConditionDegraded, metav1.ConditionTrue, v1alpha1.ReasonProgressDeadlineExceeded, msg)
+	default:
+		setCondition(bar, v1alpha1.ConditionProgressing, metav1.ConditionFalse, v1alpha1.ReasonRolloutComplete, "")
+		setCondition(bar, v1alpha1.ConditionDegraded, metav1.ConditionFalse, v1alpha1.ReasonAsExpected, "")
 	}
-	return nil
+}
+
+// setCondition sets a condition of the Bar, the transition time is only bumped
+// when the status of the condition changes.
+func setCondition(bar *v1alpha1.Bar, conditionType string, status metav1.ConditionStatus, reason, msg string) {
+	meta.SetStatusCondition(&bar.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: bar.Generation,
+		Reason:             reason,
+		Message:            msg,
+	})
 }
 
 func handleContainer(deploy *appsv1.Deployment, bar *v1alpha1.Bar, changed *bool) {
@@ -132,8 +297,8 @@ func handleContainer(deploy *appsv1.Deployment, bar *v1alpha1.Bar, changed *bool
 	}
 
 	container := deploy.Spec.Template.Spec.Containers[i]
-	if container.Image != bar.Spec.Image {
-		deploy.Spec.Template.Spec.Containers[i].Image = bar.Spec.Image
+	if image := desiredImage(bar); container.Image != image {
+		deploy.Spec.Template.Spec.Containers[i].Image = image
 		*changed = true
 	}
 }
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+	"github.com/vietanhduong/xcontroller/pkg/client/clientset/versioned/fake"
+)
+
+// newVersionedClient returns a fake clientset which checks the resourceVersion
+// of the Bar status patches and bumps it like the API server does.
+func newVersionedClient(bar *v1alpha1.Bar) *fake.Clientset {
+	client := fake.NewSimpleClientset(bar)
+	gvr := v1alpha1.SchemeGroupVersion.WithResource("bars")
+	client.PrependReactor("patch", "bars", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		obj, err := client.Tracker().Get(gvr, patch.GetNamespace(), patch.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		current := obj.(*v1alpha1.Bar)
+
+		var sent struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+		}
+		if err = json.Unmarshal(patch.GetPatch(), &sent); err != nil {
+			return true, nil, err
+		}
+		if rv := sent.Metadata.ResourceVersion; rv != "" && rv != current.ResourceVersion {
+			return true, nil, errors.NewConflict(v1alpha1.Resource("bars"), current.Name, fmt.Errorf("the object has been modified"))
+		}
+
+		data, err := json.Marshal(current)
+		if err != nil {
+			return true, nil, err
+		}
+		if data, err = jsonpatch.MergePatch(data, patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		updated := &v1alpha1.Bar{}
+		if err = json.Unmarshal(data, updated); err != nil {
+			return true, nil, err
+		}
+		version, _ := strconv.Atoi(current.ResourceVersion)
+		updated.ResourceVersion = strconv.Itoa(version + 1)
+		return true, updated, client.Tracker().Update(gvr, updated, updated.Namespace)
+	})
+	return client
+}
+
+func TestPatchStatusStaleCache(t *testing.T) {
+	cached := &v1alpha1.Bar{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "bar", ResourceVersion: "1"}}
+	cached.Status.Message = "cached"
+
+	t.Run("stale diff", func(t *testing.T) {
+		// the status was changed since the Bar was cached
+		stored := cached.DeepCopy()
+		stored.ResourceVersion = "2"
+		stored.Status.Message = "stored"
+		c := &Controller{fooClient: newVersionedClient(stored)}
+
+		desired := cached.DeepCopy()
+		desired.Status.Ready = "1/1"
+		if _, err := c.patchStatus(context.Background(), cached, desired); !errors.IsConflict(err) {
+			t.Fatalf("patchStatus() error = %v, want a conflict", err)
+		}
+	})
+
+	t.Run("own write not cached yet", func(t *testing.T) {
+		c := &Controller{fooClient: newVersionedClient(cached.DeepCopy())}
+
+		desired := cached.DeepCopy()
+		desired.Status.Message = "written"
+		if patched, err := c.patchStatus(context.Background(), cached, desired); err != nil || !patched {
+			t.Fatalf("patchStatus() = %v, %v, want true, nil", patched, err)
+		}
+
+		// the cache still holds the first version, the desired status equals
+		// it but not the stored one
+		if _, err := c.patchStatus(context.Background(), cached, cached.DeepCopy()); !errors.IsConflict(err) {
+			t.Fatalf("patchStatus() error = %v, want a conflict", err)
+		}
+
+		// the cache caught up with the write
+		current, err := c.fooClient.FooV1alpha1().Bars(cached.Namespace).Get(context.Background(), cached.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if patched, err := c.patchStatus(context.Background(), current, current.DeepCopy()); err != nil || patched {
+			t.Fatalf("patchStatus() = %v, %v, want false, nil", patched, err)
+		}
+		if n := len(c.fooClient.(*fake.Clientset).Actions()); n != 3 {
+			t.Errorf("%d actions sent, want 3", n)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+)
+
+// ownerIndex is the name of the index of the child informers which maps the
+// UID of a Bar to the objects it controls.
+const ownerIndex = "owner"
+
+// ownerIndexFunc indexes an object by the UID of the Bar controlling it.
+func ownerIndexFunc(obj interface{}) ([]string, error) {
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil
+	}
+	if ref := barControllerOf(object); ref != nil {
+		return []string{string(ref.UID)}, nil
+	}
+	return nil, nil
+}
+
+// barControllerOf returns the controller reference of the object if it is
+// controlled by a Bar.
+func barControllerOf(object metav1.Object) *metav1.OwnerReference {
+	ref := metav1.GetControllerOf(object)
+	if ref == nil || ref.Kind != "Bar" || ref.APIVersion != v1alpha1.SchemeGroupVersion.String() {
+		return nil
+	}
+	return ref
+}
+
+// ownedBy returns the objects of the informers which are controlled by the Bar.
+func ownedBy(informers []cache.SharedIndexInformer, bar *v1alpha1.Bar) ([]interface{}, error) {
+	var objs []interface{}
+	for _, informer := range informers {
+		items, err := informer.GetIndexer().ByIndex(ownerIndex, string(bar.UID))
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, items...)
+	}
+	return objs, nil
+}
+
+// ownedDeployments returns deep copies of the Deployments controlled by the
+// Bar.
+func (c *Controller) ownedDeployments(bar *v1alpha1.Bar) ([]*appsv1.Deployment, error) {
+	objs, err := ownedBy(c.deployInformers, bar)
+	if err != nil {
+		return nil, err
+	}
+
+	deploys := make([]*appsv1.Deployment, 0, len(objs))
+	for _, obj := range objs {
+		if deploy, ok := obj.(*appsv1.Deployment); ok {
+			deploys = append(deploys, deploy.DeepCopy())
+		}
+	}
+	return deploys, nil
+}
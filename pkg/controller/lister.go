@@ -0,0 +1,145 @@
+package controller
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	app_listers "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+	foo_listers "github.com/vietanhduong/xcontroller/pkg/client/listers/foo/v1alpha1"
+)
+
+// emptyIndexer backs the listers of namespaces which are not watched, every
+// Get on them returns a NotFound error.
+var emptyIndexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+// multiNamespaceBarLister implements foo_listers.BarLister over the listers of
+// the per-namespace informers, keyed by namespace.
+type multiNamespaceBarLister map[string]foo_listers.BarLister
+
+func (l multiNamespaceBarLister) List(selector labels.Selector) (ret []*v1alpha1.Bar, err error) {
+	for _, lister := range l {
+		var items []*v1alpha1.Bar
+		if items, err = lister.List(selector); err != nil {
+			return nil, err
+		}
+		ret = append(ret, items...)
+	}
+	return ret, nil
+}
+
+func (l multiNamespaceBarLister) Bars(namespace string) foo_listers.BarNamespaceLister {
+	if lister, ok := l[namespace]; ok {
+		return lister.Bars(namespace)
+	}
+	if lister, ok := l[metav1.NamespaceAll]; ok {
+		return lister.Bars(namespace)
+	}
+	return foo_listers.NewBarLister(emptyIndexer).Bars(namespace)
+}
+
+// multiNamespaceDeploymentLister implements app_listers.DeploymentLister over
+// the listers of the per-namespace informers, keyed by namespace.
+type multiNamespaceDeploymentLister map[string]app_listers.DeploymentLister
+
+func (l multiNamespaceDeploymentLister) List(selector labels.Selector) (ret []*appsv1.Deployment, err error) {
+	for _, lister := range l {
+		var items []*appsv1.Deployment
+		if items, err = lister.List(selector); err != nil {
+			return nil, err
+		}
+		ret = append(ret, items...)
+	}
+	return ret, nil
+}
+
+func (l multiNamespaceDeploymentLister) Deployments(namespace string) app_listers.DeploymentNamespaceLister {
+	if lister, ok := l[namespace]; ok {
+		return lister.Deployments(namespace)
+	}
+	if lister, ok := l[metav1.NamespaceAll]; ok {
+		return lister.Deployments(namespace)
+	}
+	return app_listers.NewDeploymentLister(emptyIndexer).Deployments(namespace)
+}
+
+// deepCopyBarLister hands out deep copies of the cached Bars, so the caller
+// may modify them without corrupting the cache shared with the informers.
+type deepCopyBarLister struct {
+	lister foo_listers.BarLister
+}
+
+func (l deepCopyBarLister) List(selector labels.Selector) ([]*v1alpha1.Bar, error) {
+	items, err := l.lister.List(selector)
+	return deepCopyBars(items), err
+}
+
+func (l deepCopyBarLister) Bars(namespace string) foo_listers.BarNamespaceLister {
+	return deepCopyBarNamespaceLister{lister: l.lister.Bars(namespace)}
+}
+
+type deepCopyBarNamespaceLister struct {
+	lister foo_listers.BarNamespaceLister
+}
+
+func (l deepCopyBarNamespaceLister) List(selector labels.Selector) ([]*v1alpha1.Bar, error) {
+	items, err := l.lister.List(selector)
+	return deepCopyBars(items), err
+}
+
+func (l deepCopyBarNamespaceLister) Get(name string) (*v1alpha1.Bar, error) {
+	bar, err := l.lister.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return bar.DeepCopy(), nil
+}
+
+func deepCopyBars(items []*v1alpha1.Bar) []*v1alpha1.Bar {
+	for i := range items {
+		items[i] = items[i].DeepCopy()
+	}
+	return items
+}
+
+// deepCopyDeploymentLister hands out deep copies of the cached Deployments,
+// so the caller may modify them without corrupting the cache shared with the
+// informers.
+type deepCopyDeploymentLister struct {
+	lister app_listers.DeploymentLister
+}
+
+func (l deepCopyDeploymentLister) List(selector labels.Selector) ([]*appsv1.Deployment, error) {
+	items, err := l.lister.List(selector)
+	return deepCopyDeployments(items), err
+}
+
+func (l deepCopyDeploymentLister) Deployments(namespace string) app_listers.DeploymentNamespaceLister {
+	return deepCopyDeploymentNamespaceLister{lister: l.lister.Deployments(namespace)}
+}
+
+type deepCopyDeploymentNamespaceLister struct {
+	lister app_listers.DeploymentNamespaceLister
+}
+
+func (l deepCopyDeploymentNamespaceLister) List(selector labels.Selector) ([]*appsv1.Deployment, error) {
+	items, err := l.lister.List(selector)
+	return deepCopyDeployments(items), err
+}
+
+func (l deepCopyDeploymentNamespaceLister) Get(name string) (*appsv1.Deployment, error) {
+	deploy, err := l.lister.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return deploy.DeepCopy(), nil
+}
+
+func deepCopyDeployments(items []*appsv1.Deployment) []*appsv1.Deployment {
+	for i := range items {
+		items[i] = items[i].DeepCopy()
+	}
+	return items
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+// Modes of the cache mutation detector.
+const (
+	MutationDetectorOff   = "off"
+	MutationDetectorLog   = "log"
+	MutationDetectorPanic = "panic"
+)
+
+// mutationCheckPeriod is the interval the whole cache is compared with its
+// snapshots at.
+const mutationCheckPeriod = 10 * time.Second
+
+type cachedObject struct {
+	kind     string
+	cached   runtime.Object
+	snapshot runtime.Object
+}
+
+// mutationDetector snapshots the objects added to the informer caches and
+// reports the cached objects which do not match their snapshot anymore.
+// Objects are only ever replaced in the caches, so a difference means the
+// controller modified an object it got from a cache.
+type mutationDetector struct {
+	panic bool
+
+	mu      sync.Mutex
+	objects map[string]cachedObject
+}
+
+func newMutationDetector(mode string) *mutationDetector {
+	return &mutationDetector{
+		panic:   mode == MutationDetectorPanic,
+		objects: make(map[string]cachedObject),
+	}
+}
+
+// watch snapshots the objects of the informer, kind prefixes their keys.
+func (d *mutationDetector) watch(informer cache.SharedIndexInformer, kind string) {
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { d.add(kind, obj) },
+		UpdateFunc: func(_, obj interface{}) { d.add(kind, obj) },
+		DeleteFunc: func(obj interface{}) {
+			if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+				d.mu.Lock()
+				delete(d.objects, kind+"/"+key)
+				d.mu.Unlock()
+			}
+		},
+	})
+}
+
+func (d *mutationDetector) add(kind string, obj interface{}) {
+	object, ok := obj.(runtime.Object)
+	if !ok {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.objects[kind+"/"+key] = cachedObject{kind: kind, cached: object, snapshot: object.DeepCopyObject()}
+}
+
+// check compares the cached objects of the given kinds and key with their
+// snapshots, it is called after each reconcile of the key.
+func (d *mutationDetector) check(key string, kinds ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, kind := range kinds {
+		d.compare(kind+"/"+key, fmt.Sprintf("after reconciling bar %s", key))
+	}
+}
+
+// run compares every cached object with its snapshot periodically until ctx
+// is done.
+func (d *mutationDetector) run(ctx context.Context) {
+	ticker := time.NewTicker(mutationCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			for id := range d.objects {
+				d.compare(id, "in periodic check")
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// compare must be called with the lock held.
+func (d *mutationDetector) compare(id, when string) {
+	obj, ok := d.objects[id]
+	if !ok || reflect.DeepEqual(obj.cached, obj.snapshot) {
+		return
+	}
+
+	metrics.CacheMutations.WithLabelValues(obj.kind).Inc()
+	msg := fmt.Sprintf("cache mutation detected %s: %s was modified in the informer cache\n%s", when, id, diff.ObjectReflectDiff(obj.snapshot, obj.cached))
+	if d.panic {
+		panic(msg)
+	}
+	log.Errorf("controller: %s", msg)
+	// report each mutation once
+	d.objects[id] = cachedObject{kind: obj.kind, cached: obj.cached, snapshot: obj.cached.DeepCopyObject()}
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Option configures a Controller created by NewController.
+type Option func(*options)
+
+type options struct {
+	shutdownGracePeriod time.Duration
+	resyncPeriod        time.Duration
+	reconcileTimeout    time.Duration
+
+	// the per-item exponential backoff and the overall token bucket of the
+	// workqueue rate limiter
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	rateLimitQPS   float64
+	rateLimitBurst int
+
+	quarantineThreshold int
+
+	sharding *ShardConfig
+
+	// maxWorkers enables the adaptive worker pool if it is greater than the
+	// number of workers given to Run
+	maxWorkers int
+
+	// maxPerNamespace caps the number of Bars of a namespace reconciled
+	// concurrently, 0 means no cap
+	maxPerNamespace int
+
+	// mutationDetector is one of the MutationDetector modes
+	mutationDetector string
+
+	namespaces         []string
+	barSelector        string
+	deploymentSelector string
+}
+
+func defaultOptions() options {
+	return options{
+		shutdownGracePeriod: 30 * time.Second,
+		resyncPeriod:        time.Hour,
+		reconcileTimeout:    time.Minute,
+		// same as workqueue.DefaultControllerRateLimiter
+		retryBaseDelay: 5 * time.Millisecond,
+		retryMaxDelay:  1000 * time.Second,
+		rateLimitQPS:   10,
+		rateLimitBurst: 100,
+
+		quarantineThreshold: 15,
+		mutationDetector:    MutationDetectorOff,
+	}
+}
+
+// rateLimiter returns the rate limiter of the workqueue, which is the max of
+// the per-item exponential backoff and the overall token bucket.
+func (o options) rateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(o.retryBaseDelay, o.retryMaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(o.rateLimitQPS), o.rateLimitBurst)},
+	)
+}
+
+// WithShutdownGracePeriod sets how long Run waits for in-flight reconciles to
+// finish once its context is done.
+func WithShutdownGracePeriod(d time.Duration) Option {
+	return func(o *options) {
+		o.shutdownGracePeriod = d
+	}
+}
+
+// WithResyncPeriod sets how often the informers replay their caches, which
+// requeues every Bar.
+func WithResyncPeriod(d time.Duration) Option {
+	return func(o *options) {
+		o.resyncPeriod = d
+	}
+}
+
+// WithReconcileTimeout sets the deadline of the API calls of a reconcile, a
+// reconcile exceeding it is retried with backoff. 0 disables the deadline.
+func WithReconcileTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.reconcileTimeout = d
+	}
+}
+
+// WithRetryBackoff sets the exponential backoff of a failing Bar, starting at
+// base and capped at max.
+func WithRetryBackoff(base, max time.Duration) Option {
+	return func(o *options) {
+		o.retryBaseDelay = base
+		o.retryMaxDelay = max
+	}
+}
+
+// WithRateLimit sets the overall rate of the workqueue in items per second
+// with the given burst.
+func WithRateLimit(qps float64, burst int) Option {
+	return func(o *options) {
+		o.rateLimitQPS = qps
+		o.rateLimitBurst = burst
+	}
+}
+
+// WithQuarantineThreshold sets the number of consecutive failed or panicked
+// reconciles after which a Bar is quarantined until its spec changes, 0
+// disables the quarantine.
+func WithQuarantineThreshold(n int) Option {
+	return func(o *options) {
+		o.quarantineThreshold = n
+	}
+}
+
+// WithNamespaceConcurrency caps the number of Bars of a namespace reconciled
+// concurrently, so a namespace cannot occupy every worker. 0 means no cap.
+func WithNamespaceConcurrency(n int) Option {
+	return func(o *options) {
+		o.maxPerNamespace = n
+	}
+}
+
+// WithMaxWorkers lets the worker pool grow up to n workers while the queue is
+// backed up, Run starts with its given number of workers and shrinks back to
+// it once the queue is idle.
+func WithMaxWorkers(n int) Option {
+	return func(o *options) {
+		o.maxWorkers = n
+	}
+}
+
+// WithNamespaces restricts the controller to the given namespaces. All
+// namespaces are watched if none is given.
+func WithNamespaces(namespaces ...string) Option {
+	return func(o *options) {
+		o.namespaces = namespaces
+	}
+}
+
+// WithBarSelector restricts the controller to the Bars matching the label
+// selector.
+func WithBarSelector(selector string) Option {
+	return func(o *options) {
+		o.barSelector = selector
+	}
+}
+
+// WithDeploymentSelector restricts the Deployments cached by the controller
+// to the ones matching the label selector. Only Deployments managed by
+// xcontroller are cached in any case.
+func WithDeploymentSelector(selector string) Option {
+	return func(o *options) {
+		o.deploymentSelector = selector
+	}
+}
+
+// WithCacheMutationDetector enables the cache mutation detector, which reports
+// the objects of the informer caches modified by the controller. mode is one
+// of MutationDetectorOff, MutationDetectorLog or MutationDetectorPanic.
+func WithCacheMutationDetector(mode string) Option {
+	return func(o *options) {
+		o.mutationDetector = mode
+	}
+}
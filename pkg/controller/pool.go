@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+const (
+	// scaleInterval is the interval the adaptive worker pool is resized at.
+	scaleInterval = time.Second
+	// scaleUpWait is the time a Bar may wait in the queue before the pool
+	// grows even if there are less queued Bars than workers.
+	scaleUpWait = time.Second
+	// scaleDownDelay is the time the queue must stay empty before the pool
+	// shrinks back to its minimum size.
+	scaleDownDelay = 30 * time.Second
+)
+
+// workerPool runs a resizable number of workers processing the items sent to
+// its work channel.
+type workerPool struct {
+	work    chan interface{}
+	process func(obj interface{})
+
+	mu    sync.Mutex
+	stops []chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newWorkerPool(process func(obj interface{})) *workerPool {
+	return &workerPool{
+		work:    make(chan interface{}),
+		process: process,
+	}
+}
+
+// size returns the number of running workers.
+func (p *workerPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.stops)
+}
+
+// resize starts or stops workers until n workers are running. A stopped
+// worker finishes the item it is processing first.
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.stops) < n {
+		stop := make(chan struct{})
+		p.stops = append(p.stops, stop)
+		p.wg.Add(1)
+		go p.run(stop)
+	}
+	for len(p.stops) > n {
+		last := len(p.stops) - 1
+		close(p.stops[last])
+		p.stops = p.stops[:last]
+	}
+	metrics.Workers.Set(float64(len(p.stops)))
+}
+
+func (p *workerPool) run(stop chan struct{}) {
+	defer p.wg.Done()
+	for {
+		select {
+		case obj, ok := <-p.work:
+			if !ok {
+				return
+			}
+			p.process(obj)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// wait blocks until every worker has returned, the work channel must be
+// closed first.
+func (p *workerPool) wait() {
+	p.wg.Wait()
+	metrics.Workers.Set(0)
+}
+
+// dispatch hands out the items of the queue to the workers of the pool until
+// the queue is shut down.
+func (c *Controller) dispatch(pool *workerPool) {
+	defer close(pool.work)
+	for {
+		obj, quit := c.queue.Get()
+		if quit {
+			return
+		}
+		pool.work <- obj
+	}
+}
+
+// autoscale grows the pool up to maxWorkers while Bars pile up or wait too
+// long in the queue, and shrinks it back to minWorkers once the queue has
+// been empty for a while.
+func (c *Controller) autoscale(ctx context.Context, pool *workerPool, minWorkers, maxWorkers int) {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	idleSince := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		depth, wait, n := c.queue.Len(), c.fair.longestWait(), pool.size()
+		if depth > 0 {
+			idleSince = time.Now()
+		}
+
+		target := n
+		switch {
+		case depth > n || wait > scaleUpWait:
+			target = n + 1
+			if depth > target {
+				target = depth
+			}
+			if target > maxWorkers {
+				target = maxWorkers
+			}
+		case depth == 0 && time.Since(idleSince) > scaleDownDelay:
+			target = minWorkers
+		}
+
+		if target != n {
+			log.Infof("controller: resizing worker pool from %d to %d workers (queue depth %d, longest wait %s)", n, target, depth, wait.Round(time.Millisecond))
+			pool.resize(target)
+		}
+	}
+}
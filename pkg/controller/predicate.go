@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
+)
+
+// updatePredicate filters the update events of an informer, an update is
+// handled if any of its funcs returns true. The dropped events are counted
+// by the name of the predicate.
+type updatePredicate struct {
+	name  string
+	funcs []func(oldObj, newObj metav1.Object) bool
+}
+
+// barUpdatePredicate ignores the status updates of Bars, most of them are
+// written by the controller itself.
+var barUpdatePredicate = updatePredicate{
+	name: "bar_update",
+	funcs: []func(oldObj, newObj metav1.Object) bool{
+		generationChanged,
+		labelsChanged,
+		annotationsChanged,
+		deletionTimestampChanged,
+	},
+}
+
+// deploymentUpdatePredicate ignores the updates of Deployments which do not
+// change their spec, ownership or the status reported on the Bar.
+var deploymentUpdatePredicate = updatePredicate{
+	name: "deployment_update",
+	funcs: []func(oldObj, newObj metav1.Object) bool{
+		generationChanged,
+		labelsChanged,
+		ownerReferencesChanged,
+		deletionTimestampChanged,
+		deploymentStatusChanged,
+	},
+}
+
+func (p updatePredicate) allow(oldObj, newObj interface{}) bool {
+	o, ok1 := oldObj.(metav1.Object)
+	n, ok2 := newObj.(metav1.Object)
+	if !ok1 || !ok2 {
+		return true
+	}
+
+	for _, f := range p.funcs {
+		if f(o, n) {
+			return true
+		}
+	}
+	metrics.EventsDropped.WithLabelValues(p.name).Inc()
+	return false
+}
+
+// isResync returns true if the update event is replayed by the periodic
+// resync of an informer.
+func isResync(oldObj, newObj interface{}) bool {
+	o, ok1 := oldObj.(metav1.Object)
+	n, ok2 := newObj.(metav1.Object)
+	return ok1 && ok2 && o.GetResourceVersion() == n.GetResourceVersion()
+}
+
+func generationChanged(oldObj, newObj metav1.Object) bool {
+	return oldObj.GetGeneration() != newObj.GetGeneration()
+}
+
+func labelsChanged(oldObj, newObj metav1.Object) bool {
+	return !reflect.DeepEqual(oldObj.GetLabels(), newObj.GetLabels())
+}
+
+func annotationsChanged(oldObj, newObj metav1.Object) bool {
+	return !reflect.DeepEqual(oldObj.GetAnnotations(), newObj.GetAnnotations())
+}
+
+func ownerReferencesChanged(oldObj, newObj metav1.Object) bool {
+	return !equality.Semantic.DeepEqual(oldObj.GetOwnerReferences(), newObj.GetOwnerReferences())
+}
+
+func deletionTimestampChanged(oldObj, newObj metav1.Object) bool {
+	return !oldObj.GetDeletionTimestamp().Equal(newObj.GetDeletionTimestamp())
+}
+
+// deploymentStatusChanged returns true if the replica counts or the reason of
+// the Progressing condition of the Deployment changed, which are the only
+// parts of its status reported on the Bar.
+func deploymentStatusChanged(oldObj, newObj metav1.Object) bool {
+	o, ok1 := oldObj.(*appsv1.Deployment)
+	n, ok2 := newObj.(*appsv1.Deployment)
+	if !ok1 || !ok2 {
+		return true
+	}
+
+	os, ns := o.Status, n.Status
+	if os.ObservedGeneration != ns.ObservedGeneration ||
+		os.Replicas != ns.Replicas ||
+		os.UpdatedReplicas != ns.UpdatedReplicas ||
+		os.ReadyReplicas != ns.ReadyReplicas ||
+		os.AvailableReplicas != ns.AvailableReplicas ||
+		os.UnavailableReplicas != ns.UnavailableReplicas {
+		return true
+	}
+	return progressingReason(o) != progressingReason(n)
+}
+
+func progressingReason(deploy *appsv1.Deployment) string {
+	for _, cond := range deploy.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing {
+			return cond.Reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+// failureTracker counts the consecutive failed reconciles of each key.
+type failureTracker struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{counts: map[string]int{}}
+}
+
+// inc records a failure of the key and returns its number of consecutive
+// failures.
+func (t *failureTracker) inc(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[key]++
+	return t.counts[key]
+}
+
+// get returns the number of consecutive failures of the key.
+func (t *failureTracker) get(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.counts[key]
+}
+
+// reset forgets the failures of the key.
+func (t *failureTracker) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.counts, key)
+}
+
+// panicError is the error of a reconcile which panicked.
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string { return fmt.Sprintf("panic: %v", e.value) }
+
+// isQuarantined returns true if the current generation of the Bar has been
+// quarantined.
+func isQuarantined(bar *v1alpha1.Bar) bool {
+	cond := meta.FindStatusCondition(bar.Status.Conditions, v1alpha1.ConditionQuarantined)
+	return cond != nil && cond.Status == metav1.ConditionTrue && cond.ObservedGeneration == bar.Generation
+}
+
+// quarantineBar stops processing the Bar of the key until its generation
+// changes, after it failed too many times in a row.
+func (c *Controller) quarantineBar(ctx context.Context, key string, failures int, err error) {
+	namespace, name, e := cache.SplitMetaNamespaceKey(key)
+	if e != nil {
+		runtime.HandleError(e)
+		return
+	}
+
+	reason, _ := errorReason(err)
+	if reason != v1alpha1.ReasonPanic {
+		reason = v1alpha1.ReasonTooManyFailures
+	}
+	msg := fmt.Sprintf("Quarantined after %d consecutive failures, last error: %v", failures, err)
+	log.FromContext(ctx).Warn("quarantined", "failures", failures, "reason", reason, "error", err)
+	metrics.QuarantinedBars.WithLabelValues(namespace, name).Set(1)
+
+	// the cached Bar may not have the status of the failed reconcile yet
+	bar, e := c.fooClient.FooV1alpha1().Bars(namespace).Get(ctx, name, metav1.GetOptions{})
+	if e != nil {
+		log.FromContext(ctx).Error("get bar to quarantine failed", "error", e)
+		return
+	}
+
+	desired := bar.DeepCopy()
+	setCondition(desired, v1alpha1.ConditionQuarantined, metav1.ConditionTrue, reason, msg)
+	if _, e = c.patchStatus(ctx, bar, desired); e != nil {
+		log.FromContext(ctx).Error("patch bar status of the quarantined bar failed", "error", e)
+		return
+	}
+	c.recorder.Eventf(bar, corev1.EventTypeWarning, reason, msg)
+}
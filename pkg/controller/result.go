@@ -0,0 +1,15 @@
+package controller
+
+import "time"
+
+// Result is the outcome of a reconcile which did not fail. A zero Result
+// means the Bar is in its desired state and is only reconciled again on
+// the next event or resync.
+type Result struct {
+	// Requeue requeues the Bar with the rate limited backoff of the queue.
+	Requeue bool
+
+	// RequeueAfter requeues the Bar after the given duration if it is greater
+	// than 0, it takes precedence over Requeue.
+	RequeueAfter time.Duration
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+// deploymentTimedOutReason is set on the Progressing condition of a Deployment
+// when its rollout does not make any progress within progressDeadlineSeconds.
+const deploymentTimedOutReason = "ProgressDeadlineExceeded"
+
+// rolloutPollInterval is the interval to check again the rollout of a
+// Deployment which is still progressing.
+const rolloutPollInterval = 10 * time.Second
+
+type rolloutState int
+
+const (
+	rolloutProgressing rolloutState = iota
+	rolloutComplete
+	rolloutFailed
+)
+
+// getRolloutState returns the state of the Deployment rollout and a message
+// describing it. It follows the same rules as `kubectl rollout status`.
+func getRolloutState(deploy *appsv1.Deployment) (rolloutState, string) {
+	if deploy.Generation > deploy.Status.ObservedGeneration {
+		return rolloutProgressing, "Waiting for deployment spec update to be observed"
+	}
+
+	for _, cond := range deploy.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == deploymentTimedOutReason {
+			return rolloutFailed, fmt.Sprintf("Deployment %q exceeded its progress deadline: %s", deploy.Name, cond.Message)
+		}
+	}
+
+	var replicas int32 = 1
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+
+	status := deploy.Status
+	if status.UpdatedReplicas < replicas {
+		return rolloutProgressing, fmt.Sprintf("Waiting for rollout to finish: %d out of %d new replicas have been updated", status.UpdatedReplicas, replicas)
+	}
+	if status.Replicas > status.UpdatedReplicas {
+		return rolloutProgressing, fmt.Sprintf("Waiting for rollout to finish: %d old replicas are pending termination", status.Replicas-status.UpdatedReplicas)
+	}
+	if status.AvailableReplicas < status.UpdatedReplicas {
+		return rolloutProgressing, fmt.Sprintf("Waiting for rollout to finish: %d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas)
+	}
+	return rolloutComplete, ""
+}
+
+// deploymentImage returns the image of the Bar container in the Deployment.
+func deploymentImage(deploy *appsv1.Deployment, bar *v1alpha1.Bar) string {
+	for _, container := range deploy.Spec.Template.Spec.Containers {
+		if container.Name == buildContainerName(bar) {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+// isRolledBack returns true if the image requested by the Bar has been rolled
+// back automatically. Other changes of the spec, such as the replicas, do not
+// retry the image.
+func isRolledBack(bar *v1alpha1.Bar) bool {
+	return bar.Status.RolledBackImage != "" && bar.Status.RolledBackImage == bar.Spec.Image
+}
+
+// shouldRollback returns true and the reason if the rollout of the Deployment
+// failed and must be reverted to the last ready image of the Bar.
+func (c *Controller) shouldRollback(ctx context.Context, deploy *appsv1.Deployment, bar *v1alpha1.Bar, state rolloutState) (string, bool) {
+	if bar.Spec.AutoRollback == nil || !bar.Spec.AutoRollback.Enabled || isRolledBack(bar) {
+		return "", false
+	}
+
+	image := deploymentImage(deploy, bar)
+	if bar.Status.CurrentImage == "" || bar.Status.CurrentImage == image || state == rolloutComplete {
+		return "", false
+	}
+
+	if state == rolloutFailed {
+		return v1alpha1.ReasonProgressDeadlineExceeded, true
+	}
+
+	threshold := bar.Spec.AutoRollback.CrashLoopThreshold
+	if threshold <= 0 {
+		return "", false
+	}
+
+	pods, err := c.kubeClient.CoreV1().Pods(bar.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(buildLabels(bar)).String(),
+	})
+	if err != nil {
+		log.FromContext(ctx).Error("list pods failed", "error", err)
+		return "", false
+	}
+
+	for _, pod := range pods.Items {
+		if isCrashLooping(&pod, buildContainerName(bar), image, threshold) {
+			return v1alpha1.ReasonCrashLoop, true
+		}
+	}
+	return "", false
+}
+
+// rollback records the failed image in the Bar status, then reverts the
+// Deployment to the last ready image of the Bar. The status is written first,
+// so the next reconcile completes the rollback if the Deployment update fails.
+func (c *Controller) rollback(ctx context.Context, deploy *appsv1.Deployment, bar *v1alpha1.Bar, reason string) error {
+	image := deploymentImage(deploy, bar)
+	current := bar.DeepCopy()
+	bar.Status.RolledBackImage = image
+	bar.Status.RolledBackGeneration = bar.Generation
+	if _, err := c.patchStatus(ctx, current, bar); err != nil {
+		bar.Status.RolledBackImage = current.Status.RolledBackImage
+		bar.Status.RolledBackGeneration = current.Status.RolledBackGeneration
+		log.FromContext(ctx).Error("record rollback failed", "error", err)
+		return err
+	}
+
+	deploy = deploy.DeepCopy()
+	var changed bool
+	handleContainer(deploy, bar, &changed)
+	if _, err := c.kubeClient.AppsV1().Deployments(bar.Namespace).Update(ctx, deploy, metav1.UpdateOptions{}); err != nil {
+		log.FromContext(ctx).Error("rollback deployment failed", "error", err)
+		return err
+	}
+
+	log.FromContext(ctx).Info("rolled back deployment", "image", image, "rolledBackTo", bar.Status.CurrentImage, "reason", reason)
+	c.recorder.Eventf(bar, corev1.EventTypeWarning, v1alpha1.ReasonRolledBack,
+		"Rollout of image %q failed (%s), rolled back to image %q", image, reason, bar.Status.CurrentImage)
+	return nil
+}
+
+// isCrashLooping returns true if the container of the pod runs the given image
+// and has restarted at least threshold times.
+func isCrashLooping(pod *corev1.Pod, containerName, image string, threshold int32) bool {
+	var found bool
+	for _, container := range pod.Spec.Containers {
+		if container.Name == containerName && container.Image == image {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == containerName && status.RestartCount >= threshold {
+			return true
+		}
+	}
+	return false
+}
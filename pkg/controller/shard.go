@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
+	"github.com/vietanhduong/xcontroller/pkg/util/log"
+)
+
+// labelShardGroup is set on the member Leases of a shard group.
+const labelShardGroup = "xcontroller.anhdv.dev/shard-group"
+
+// ringReplicas is the number of points of each member on the hash ring.
+const ringReplicas = 100
+
+// ShardConfig configures the sharding of the Bars across controller replicas.
+// Every replica holds a Lease named after the group and its identity, the
+// Bars are split between the replicas with a live Lease by a consistent hash
+// of their namespace/name.
+type ShardConfig struct {
+	// Group is the name of the shard group, replicas with the same group
+	// share the Bars.
+	Group string
+	// Namespace is the namespace of the member Leases.
+	Namespace string
+	// Identity is the unique name of the replica in the group.
+	Identity string
+	// LeaseDuration is the time after which a member which stopped renewing
+	// its Lease leaves the group.
+	LeaseDuration time.Duration
+	// RenewPeriod is the interval the Lease is renewed and the members are
+	// listed at.
+	RenewPeriod time.Duration
+	// HandoffDelay is the time a replica waits before processing the Bars it
+	// gained from another member, so the previous owner has observed the
+	// change and finished its in-flight reconciles. Defaults to the reconcile
+	// timeout plus the lease duration.
+	HandoffDelay time.Duration
+}
+
+// WithSharding splits the Bars between the controller replicas of the shard
+// group, each replica only processes the Bars of its shard.
+func WithSharding(cfg ShardConfig) Option {
+	return func(o *options) {
+		o.sharding = &cfg
+	}
+}
+
+// hashRing maps keys to the members of a shard group by consistent hashing,
+// so only the keys of a joining or leaving member move.
+type hashRing struct {
+	members []string
+	points  []uint64
+	owners  map[uint64]string
+}
+
+func newHashRing(members []string) *hashRing {
+	r := &hashRing{members: members, owners: map[uint64]string{}}
+	for _, member := range members {
+		for i := 0; i < ringReplicas; i++ {
+			p := hashKey(member + "#" + strconv.Itoa(i))
+			r.points = append(r.points, p)
+			r.owners[p] = member
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// hashKey hashes the key with the first 8 bytes of its SHA-256 sum. The points
+// of a member only differ by their suffix, a weaker hash clusters them and
+// splits the keys unevenly.
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// owner returns the member owning the key.
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+func (r *hashRing) has(member string) bool {
+	for _, m := range r.members {
+		if m == member {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *hashRing) equal(members []string) bool {
+	if len(r.members) != len(members) {
+		return false
+	}
+	for i := range members {
+		if r.members[i] != members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sharder keeps the membership of the replica in its shard group and decides
+// which Bars it owns.
+type sharder struct {
+	cfg        ShardConfig
+	kubeClient kubernetes.Interface
+
+	mu sync.RWMutex
+	// ring is the current ring and settled is the last ring which was
+	// current for longer than the handoff delay
+	ring    *hashRing
+	settled *hashRing
+	changed time.Time
+	renewed time.Time
+}
+
+func newSharder(cfg ShardConfig, kubeClient kubernetes.Interface) *sharder {
+	return &sharder{cfg: cfg, kubeClient: kubeClient}
+}
+
+// owns returns true if the Bar of the key belongs to the shard of the replica.
+// A Bar gained from another member is only owned once the handoff delay has
+// passed, and no Bar is owned while the Lease of the replica is not renewed.
+func (s *sharder) owns(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.ring == nil || time.Since(s.renewed) > s.cfg.LeaseDuration {
+		return false
+	}
+	if s.ring.owner(key) != s.cfg.Identity {
+		return false
+	}
+	if time.Since(s.changed) >= s.cfg.HandoffDelay {
+		return true
+	}
+	return s.settled != nil && s.settled.owner(key) == s.cfg.Identity
+}
+
+// run renews the Lease of the replica and refreshes the members of the group
+// until ctx is done, then releases the Lease. onSettled is called once the
+// handoff delay after a membership change has passed, so the Bars gained
+// from other members can be enqueued.
+func (s *sharder) run(ctx context.Context, onSettled func()) {
+	ticker := time.NewTicker(s.cfg.RenewPeriod)
+	defer ticker.Stop()
+
+	for {
+		if err := s.renew(ctx); err != nil {
+			log.Errorf("shard %s: renew lease failed: %v", s.cfg.Group, err)
+		} else if err = s.refresh(ctx); err != nil {
+			log.Errorf("shard %s: list members failed: %v", s.cfg.Group, err)
+		}
+
+		s.mu.Lock()
+		settle := s.ring != nil && s.ring != s.settled && time.Since(s.changed) >= s.cfg.HandoffDelay
+		if settle {
+			s.settled = s.ring
+		}
+		s.mu.Unlock()
+
+		if settle {
+			log.Infof("shard %s: handoff completed, taking over the Bars of the shard", s.cfg.Group)
+			onSettled()
+		}
+
+		select {
+		case <-ctx.Done():
+			s.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *sharder) leaseName() string {
+	return s.cfg.Group + "-" + s.cfg.Identity
+}
+
+// renew creates or renews the Lease of the replica.
+func (s *sharder) renew(ctx context.Context) error {
+	leases := s.kubeClient.CoordinationV1().Leases(s.cfg.Namespace)
+	now := metav1.NewMicroTime(time.Now())
+	identity := s.cfg.Identity
+	duration := int32(s.cfg.LeaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, s.leaseName(), metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.leaseName(),
+				Namespace: s.cfg.Namespace,
+				Labels:    map[string]string{labelShardGroup: s.cfg.Group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+	case err == nil:
+		if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != s.cfg.Identity {
+			return fmt.Errorf("lease %s/%s is held by %s", s.cfg.Namespace, s.leaseName(), *lease.Spec.HolderIdentity)
+		}
+		lease.Spec.HolderIdentity = &identity
+		lease.Spec.LeaseDurationSeconds = &duration
+		lease.Spec.RenewTime = &now
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.renewed.IsZero() && now.Sub(s.renewed) > s.cfg.LeaseDuration {
+		// the other members may have taken over the Bars of the replica while
+		// its Lease was lapsed, wait for the handoff delay again
+		log.Warnf("shard %s: lease renewed after it lapsed, waiting for the handoff delay", s.cfg.Group)
+		s.settled = nil
+		s.changed = now.Time
+	}
+	s.renewed = now.Time
+	return nil
+}
+
+// refresh lists the members with a live Lease and rebuilds the ring if they
+// changed.
+func (s *sharder) refresh(ctx context.Context) error {
+	list, err := s.kubeClient.CoordinationV1().Leases(s.cfg.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{labelShardGroup: s.cfg.Group}).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var members []string
+	for _, lease := range list.Items {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		if spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second).After(now) {
+			members = append(members, *spec.HolderIdentity)
+		}
+	}
+	sort.Strings(members)
+	metrics.ShardMembers.Set(float64(len(members)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ring != nil && s.ring.equal(members) {
+		return nil
+	}
+	log.Infof("shard %s: members changed to %v", s.cfg.Group, members)
+	if s.ring != nil && !s.ring.has(s.cfg.Identity) {
+		// the replica rejoins the group, its previous shard is stale
+		s.settled = nil
+	}
+	s.ring = newHashRing(members)
+	s.changed = now
+	return nil
+}
+
+// release deletes the Lease of the replica, so the other members take over
+// its Bars without waiting for the Lease to expire.
+func (s *sharder) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RenewPeriod)
+	defer cancel()
+	err := s.kubeClient.CoordinationV1().Leases(s.cfg.Namespace).Delete(ctx, s.leaseName(), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		log.Errorf("shard %s: release lease failed: %v", s.cfg.Group, err)
+	}
+}
+
+// enqueue adds the key to the queue if its Bar belongs to the shard of the
+// replica.
+func (c *Controller) enqueue(key string) {
+	if c.shards != nil && !c.shards.owns(key) {
+		return
+	}
+	c.queue.Add(key)
+}
+
+// enqueueOwned adds every Bar of the shard of the replica to the queue.
+func (c *Controller) enqueueOwned() {
+	bars, err := c.barLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("shard: list bars failed: %v", err)
+		return
+	}
+	for _, bar := range bars {
+		if key, err := cache.MetaNamespaceKeyFunc(bar); err == nil {
+			c.enqueue(key)
+		}
+	}
+}
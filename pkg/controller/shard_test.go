@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func ringMembers(n int) []string {
+	members := make([]string, n)
+	for i := range members {
+		members[i] = fmt.Sprintf("xcontroller-6d4cf56db6-%05d", i)
+	}
+	return members
+}
+
+func ringKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("team-%d/bar-%d", i%50, i)
+	}
+	return keys
+}
+
+func TestHashRingBalance(t *testing.T) {
+	keys := ringKeys(20000)
+	for _, n := range []int{2, 3, 5, 10} {
+		t.Run(fmt.Sprintf("%d-members", n), func(t *testing.T) {
+			ring := newHashRing(ringMembers(n))
+			counts := map[string]int{}
+			for _, key := range keys {
+				counts[ring.owner(key)]++
+			}
+
+			fair := float64(len(keys)) / float64(n)
+			for _, member := range ringMembers(n) {
+				if share := float64(counts[member]) / fair; share < 0.7 || share > 1.3 {
+					t.Errorf("member %s owns %d keys, %.0f%% of its fair share", member, counts[member], share*100)
+				}
+			}
+		})
+	}
+}
+
+func TestHashRingMovement(t *testing.T) {
+	keys := ringKeys(20000)
+	members := ringMembers(4)
+
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+		// moved is the member all moved keys must come from or go to
+		moved string
+	}{
+		{name: "join", before: members[:3], after: members, moved: members[3]},
+		{name: "leave", before: members, after: append(append([]string{}, members[:1]...), members[2:]...), moved: members[1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := newHashRing(tt.before), newHashRing(tt.after)
+			var moved int
+			for _, key := range keys {
+				from, to := before.owner(key), after.owner(key)
+				if from == to {
+					continue
+				}
+				moved++
+				if from != tt.moved && to != tt.moved {
+					t.Fatalf("key %s moved from %s to %s, only keys of %s should move", key, from, to, tt.moved)
+				}
+			}
+			if limit := len(keys) * 2 / len(members); moved > limit {
+				t.Errorf("%d keys moved, want at most %d", moved, limit)
+			}
+		})
+	}
+}
+
+func TestSharderOwns(t *testing.T) {
+	members := ringMembers(2)
+	current := newHashRing(members)
+	previous := newHashRing(members[:1])
+
+	// a key owned by members[0] in both rings and a key gained by members[1]
+	var kept, gained string
+	for _, key := range ringKeys(1000) {
+		switch {
+		case kept == "" && current.owner(key) == members[0]:
+			kept = key
+		case gained == "" && current.owner(key) == members[1]:
+			gained = key
+		}
+	}
+
+	cfg := ShardConfig{LeaseDuration: 15 * time.Second, HandoffDelay: time.Minute}
+	now := time.Now()
+	tests := []struct {
+		name     string
+		identity string
+		key      string
+		settled  *hashRing
+		changed  time.Time
+		renewed  time.Time
+		want     bool
+	}{
+		{name: "owned after handoff", identity: members[1], key: gained, settled: previous, changed: now.Add(-2 * time.Minute), renewed: now, want: true},
+		{name: "gained during handoff", identity: members[1], key: gained, settled: previous, changed: now, renewed: now, want: false},
+		{name: "kept during handoff", identity: members[0], key: kept, settled: previous, changed: now, renewed: now, want: true},
+		{name: "lost during handoff", identity: members[0], key: gained, settled: previous, changed: now, renewed: now, want: false},
+		{name: "no settled ring during handoff", identity: members[0], key: kept, changed: now, renewed: now, want: false},
+		{name: "lease lapsed", identity: members[0], key: kept, settled: current, changed: now.Add(-2 * time.Minute), renewed: now.Add(-time.Minute), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.Identity = tt.identity
+			s := &sharder{cfg: cfg, ring: current, settled: tt.settled, changed: tt.changed, renewed: tt.renewed}
+			if got := s.owns(tt.key); got != tt.want {
+				t.Errorf("owns(%s) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharderRenewAfterLapse(t *testing.T) {
+	cfg := ShardConfig{Group: "g", Namespace: "default", Identity: "a", LeaseDuration: 15 * time.Second, HandoffDelay: time.Minute}
+	ring := newHashRing([]string{"a"})
+	s := newSharder(cfg, fake.NewSimpleClientset())
+	s.ring, s.settled = ring, ring
+	s.changed = time.Now().Add(-time.Hour)
+	s.renewed = time.Now().Add(-time.Minute)
+
+	if err := s.renew(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.settled != nil || time.Since(s.changed) > time.Second {
+		t.Fatalf("settled ring kept after the lease lapsed")
+	}
+	for _, key := range ringKeys(100) {
+		if s.owns(key) {
+			t.Fatalf("key %s owned before the handoff delay after the lease lapsed", key)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/vietanhduong/xcontroller/pkg/apis/foo/v1alpha1"
+)
+
+// lastAppliedAnnotation is set by `kubectl apply` and holds a full copy of the
+// applied object.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// childSelector returns the label selector of the child informers. Only the
+// objects managed by xcontroller are cached, extra is an optional user
+// provided selector which is combined with it.
+func childSelector(extra string) string {
+	managed, _ := labels.NewRequirement(labelManagedBy, selection.Equals, []string{managedBy})
+	if extra == "" {
+		return managed.String()
+	}
+	return managed.String() + "," + extra
+}
+
+// setupChildInformer configures an informer of objects owned by Bars, it must
+// be called before the informer is started.
+func setupChildInformer(informer cache.SharedIndexInformer) {
+	utilruntime.Must(informer.SetTransform(stripObject))
+	utilruntime.Must(informer.AddIndexers(cache.Indexers{ownerIndex: ownerIndexFunc}))
+}
+
+// stripObject drops the fields the controller never reads before an object is
+// stored in an informer cache. Children are written back with Update, so only
+// the fields which the API server keeps when they are omitted are dropped.
+func stripObject(obj interface{}) (interface{}, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		// e.g. cache.DeletedFinalStateUnknown, keep it as is
+		return obj, nil
+	}
+	accessor.SetManagedFields(nil)
+
+	if bar, ok := obj.(*v1alpha1.Bar); ok && bar.Annotations != nil {
+		delete(bar.Annotations, lastAppliedAnnotation)
+	}
+	return obj, nil
+}
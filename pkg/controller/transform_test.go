@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// BenchmarkDeploymentCache compares the memory retained by a Deployment cache
+// holding every Deployment of a cluster with one holding only the Deployments
+// managed by xcontroller with their heavy fields stripped.
+func BenchmarkDeploymentCache(b *testing.B) {
+	const total, managed = 5000, 500
+
+	selector, err := labels.Parse(childSelector(""))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("all", func(b *testing.B) {
+		benchmarkDeploymentCache(b, total, managed, func(deploy *appsv1.Deployment) (interface{}, bool) {
+			return deploy, true
+		})
+	})
+
+	b.Run("managed-stripped", func(b *testing.B) {
+		benchmarkDeploymentCache(b, total, managed, func(deploy *appsv1.Deployment) (interface{}, bool) {
+			if !selector.Matches(labels.Set(deploy.Labels)) {
+				return nil, false
+			}
+			obj, _ := stripObject(deploy)
+			return obj, true
+		})
+	})
+}
+
+func benchmarkDeploymentCache(b *testing.B, total, managed int, admit func(*appsv1.Deployment) (interface{}, bool)) {
+	b.ReportAllocs()
+	var retained int64
+	for i := 0; i < b.N; i++ {
+		before := heapAlloc()
+		store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		for j := 0; j < total; j++ {
+			if obj, ok := admit(newBenchmarkDeployment(j, j < managed)); ok {
+				if err := store.Add(obj); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		retained += heapAlloc() - before
+		runtime.KeepAlive(store)
+	}
+	b.ReportMetric(float64(retained)/float64(b.N), "cache-bytes")
+}
+
+func heapAlloc() int64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc)
+}
+
+func newBenchmarkDeployment(i int, managed bool) *appsv1.Deployment {
+	name := fmt.Sprintf("app-%d", i)
+	labels := map[string]string{"app.kubernetes.io/name": name}
+	if managed {
+		labels[labelManagedBy] = managedBy
+	}
+
+	fields := []byte(`{"f:metadata":{"f:labels":{` + strings.Repeat(`"f:label":{},`, 40) + `"f:last":{}}},"f:spec":{"f:template":{` + strings.Repeat(`"f:field":{},`, 60) + `"f:last":{}}}}`)
+	var replicas int32 = 2
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: fmt.Sprintf("team-%d", i%20),
+			Labels:    labels,
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl-client-side-apply", Operation: metav1.ManagedFieldsOperationUpdate, FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: fields}},
+				{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate, FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: fields}, Subresource: "status"},
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: metav1.SetAsLabelSelector(labels),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:  name,
+					Image: "nginx:latest",
+					Env:   []corev1.EnvVar{{Name: "APP_NAME", Value: name}},
+				}}},
+			},
+		},
+	}
+}
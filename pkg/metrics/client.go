@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+)
+
+var (
+	clientRequestLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rest_client",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of Kubernetes API requests by verb and path.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"verb", "path"})
+
+	clientRequestResult = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rest_client",
+		Name:      "requests_total",
+		Help:      "Number of Kubernetes API requests by status code, method and host.",
+	}, []string{"code", "method", "host"})
+)
+
+func init() {
+	Registry.MustRegister(clientRequestLatency, clientRequestResult)
+	clientmetrics.Register(clientmetrics.RegisterOpts{
+		RequestLatency: latencyAdapter{},
+		RequestResult:  resultAdapter{},
+	})
+}
+
+type latencyAdapter struct{}
+
+func (latencyAdapter) Observe(_ context.Context, verb string, u url.URL, latency time.Duration) {
+	clientRequestLatency.WithLabelValues(verb, u.Path).Observe(latency.Seconds())
+}
+
+type resultAdapter struct{}
+
+func (resultAdapter) Increment(_ context.Context, code, method, host string) {
+	clientRequestResult.WithLabelValues(code, method, host).Inc()
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "xcontroller"
+
+// Reconcile results.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+	ResultTimeout = "timeout"
+)
+
+// Classes of reconcile errors.
+const (
+	// ErrorTransient errors are retried with backoff.
+	ErrorTransient = "transient"
+	// ErrorTerminal errors are not retried until the Bar changes.
+	ErrorTerminal = "terminal"
+)
+
+var (
+	// Registry holds every metric exposed by the controller.
+	Registry = prometheus.NewRegistry()
+
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Total number of Bar reconciles by result.",
+	}, []string{"result"})
+
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of Bar reconciles by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"result"})
+
+	ReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Total number of failed Bar reconciles by error class and reason.",
+	}, []string{"class", "reason"})
+
+	QuarantinedBars = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "quarantined_bars",
+		Help:      "Set to 1 for each Bar which is quarantined after failing too many times in a row.",
+	}, []string{"namespace", "name"})
+
+	EventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dropped_total",
+		Help:      "Total number of informer update events dropped by predicate.",
+	}, []string{"predicate"})
+
+	Workers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers",
+		Help:      "Number of running workers.",
+	})
+
+	InflightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rest_client",
+		Name:      "inflight_requests",
+		Help:      "Number of Kubernetes API requests in flight, watches excluded.",
+	})
+
+	ShardMembers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "shard_members",
+		Help:      "Number of live members of the shard group of the replica.",
+	})
+
+	CacheMutations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_mutations_total",
+		Help:      "Total number of informer cache objects found mutated by the cache mutation detector, by kind.",
+	}, []string{"kind"})
+
+	BarDesiredReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bar_desired_replicas",
+		Help:      "Number of replicas desired by a Bar.",
+	}, []string{"namespace", "name"})
+
+	BarReadyReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bar_ready_replicas",
+		Help:      "Number of ready replicas of a Bar.",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ReconcileTotal,
+		ReconcileDuration,
+		ReconcileErrors,
+		QuarantinedBars,
+		EventsDropped,
+		Workers,
+		InflightRequests,
+		ShardMembers,
+		CacheMutations,
+		BarDesiredReplicas,
+		BarReadyReplicas,
+	)
+}
+
+// Handler returns the HTTP handler serving the metrics of Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// DeleteBar removes the per-Bar metrics of a deleted Bar.
+func DeleteBar(namespace, name string) {
+	BarDesiredReplicas.DeleteLabelValues(namespace, name)
+	BarReadyReplicas.DeleteLabelValues(namespace, name)
+	QuarantinedBars.DeleteLabelValues(namespace, name)
+}
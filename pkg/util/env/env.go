@@ -16,6 +16,23 @@ func StringFromEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// StringsFromEnv returns the comma separated list of the env variable for the
+// given key and falls back to the given defaultValue if not set
+func StringsFromEnv(key string, defaultValue []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+
+	var values []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
 // ParseNumFromEnv helper function to parse a number from an environment variable. Returns a
 // default if env is not set, is not parseable to a number, exceeds max (if
 // max is greater than 0) or is less than min.
@@ -37,6 +54,27 @@ func ParseNumFromEnv(env string, defaultValue, min, max int) int {
 	return num
 }
 
+// ParseFloatFromEnv helper function to parse a float from an environment variable. Returns a
+// default if env is not set, is not parseable to a float, exceeds max or is
+// less than min.
+func ParseFloatFromEnv(env string, defaultValue, min, max float64) float64 {
+	str := os.Getenv(env)
+	if str == "" {
+		return defaultValue
+	}
+	num, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return defaultValue
+	}
+	if num < min {
+		return defaultValue
+	}
+	if num > max {
+		return defaultValue
+	}
+	return num
+}
+
 // ParseDurationFromEnv helper function to parse a time duration from an environment variable. Returns a
 // default if env is not set, is not parseable to a duration, exceeds max (if
 // max is greater than 0) or is less than min.
@@ -0,0 +1,53 @@
+// Package inflight limits the number of concurrent Kubernetes API requests.
+package inflight
+
+import (
+	"net/http"
+	"strings"
+
+	"k8s.io/client-go/transport"
+
+	"github.com/vietanhduong/xcontroller/pkg/metrics"
+)
+
+// NewLimiter returns a transport wrapper which allows at most max API
+// requests in flight across every client wrapped by it. Watch requests are
+// long running and Lease requests must not be delayed past the renew
+// deadline of the leader election, neither is limited. A max lower than 1
+// disables the limit.
+func NewLimiter(max int) transport.WrapperFunc {
+	var sem chan struct{}
+	if max > 0 {
+		sem = make(chan struct{}, max)
+	}
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &limiter{sem: sem, rt: rt}
+	}
+}
+
+type limiter struct {
+	sem chan struct{}
+	rt  http.RoundTripper
+}
+
+func (l *limiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if l.sem == nil || req.URL.Query().Get("watch") == "true" || strings.HasPrefix(req.URL.Path, "/apis/coordination.k8s.io/") {
+		return l.rt.RoundTrip(req)
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	metrics.InflightRequests.Inc()
+	defer func() {
+		metrics.InflightRequests.Dec()
+		<-l.sem
+	}()
+	return l.rt.RoundTrip(req)
+}
+
+// WrappedRoundTripper returns the wrapped round tripper, so client-go can
+// still find the underlying transport.
+func (l *limiter) WrappedRoundTripper() http.RoundTripper { return l.rt }
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"io"
 	"os"
 	"strings"
@@ -13,22 +14,35 @@ import (
 	"github.com/vietanhduong/xcontroller/pkg/util/env"
 )
 
+// Formats of the log output.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
 var logger *zap.Logger
 
+// atomicLevel is shared by the loggers of NewLogger and NewK8sLogger, so a
+// level change at runtime applies to both.
+var atomicLevel = zap.NewAtomicLevel()
+
 func init() {
 	w := io.Writer(os.Stdout)
-	logger = zap.New(zapcore.NewCore(DefaultEncoder(), zapcore.AddSync(w), parseLevel(env.StringFromEnv("LOG_LEVEL", "info"))), zap.AddCaller())
+	atomicLevel.SetLevel(parseLevel(env.StringFromEnv("LOG_LEVEL", "info")))
+	logger = zap.New(zapcore.NewCore(NewEncoder(env.StringFromEnv("LOG_FORMAT", FormatConsole)), zapcore.AddSync(w), atomicLevel), zap.AddCaller())
 	zap.ReplaceGlobals(logger) // set as default logger
 }
 
-func NewLogger(level string) *zap.Logger {
+func NewLogger(level, format string) *zap.Logger {
 	w := io.Writer(os.Stdout)
-	logger = zap.New(zapcore.NewCore(DefaultEncoder(), zapcore.AddSync(w), parseLevel(level)), zap.AddCaller())
+	atomicLevel.SetLevel(parseLevel(level))
+	logger = zap.New(zapcore.NewCore(NewEncoder(format), zapcore.AddSync(w), atomicLevel), zap.AddCaller())
 	return logger
 }
 
-func NewK8sLogger(level string) logr.Logger {
-	return kzap.New(kzap.Level(parseLevel(level)), kzap.UseDevMode(true), kzap.WriteTo(os.Stdout), kzap.Encoder(DefaultEncoder()))
+func NewK8sLogger(level, format string) logr.Logger {
+	atomicLevel.SetLevel(parseLevel(level))
+	return kzap.New(kzap.Level(atomicLevel), kzap.UseDevMode(format != FormatJSON), kzap.WriteTo(os.Stdout), kzap.Encoder(NewEncoder(format)))
 }
 
 func DefaultEncoder() zapcore.Encoder {
@@ -38,6 +52,33 @@ func DefaultEncoder() zapcore.Encoder {
 	return encoder
 }
 
+// NewEncoder returns the encoder of the given format, the console encoder is
+// returned for an unknown format.
+func NewEncoder(format string) zapcore.Encoder {
+	if strings.ToLower(format) != FormatJSON {
+		return DefaultEncoder()
+	}
+	conf := zap.NewProductionEncoderConfig()
+	conf.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zapcore.NewJSONEncoder(conf)
+}
+
+// Level returns the level shared by the loggers. It serves GET and PUT
+// requests to read and change the level over HTTP.
+func Level() zap.AtomicLevel {
+	return atomicLevel
+}
+
+// SetLevel changes the level of the loggers at runtime.
+func SetLevel(level string) error {
+	l, err := zapcore.ParseLevel(strings.ToLower(strings.TrimSpace(level)))
+	if err != nil {
+		return err
+	}
+	atomicLevel.SetLevel(l)
+	return nil
+}
+
 func parseLevel(level string) zapcore.Level {
 	l, err := zapcore.ParseLevel(strings.ToLower(level))
 	if err != nil {
@@ -85,3 +126,52 @@ func Fatal(args ...interface{}) {
 func Fatalf(format string, args ...interface{}) {
 	zap.L().WithOptions(zap.AddCallerSkip(1)).Sugar().Fatalf(format, args...)
 }
+
+// Logger logs messages with a set of key/value pairs attached, such as the
+// Bar being reconciled.
+type Logger struct {
+	s *zap.SugaredLogger
+}
+
+type contextKey struct{}
+
+// With returns a Logger attaching the given key/value pairs to every message.
+func With(keysAndValues ...interface{}) *Logger {
+	return &Logger{s: zap.L().WithOptions(zap.AddCallerSkip(1)).Sugar().With(keysAndValues...)}
+}
+
+// FromContext returns the Logger stored in ctx by IntoContext, or a Logger
+// without key/value pairs if there is none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return With()
+}
+
+// IntoContext returns a copy of ctx carrying the Logger.
+func IntoContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// With returns a Logger attaching the given key/value pairs in addition to
+// the ones of l.
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	return &Logger{s: l.s.With(keysAndValues...)}
+}
+
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.s.Debugw(msg, keysAndValues...)
+}
+
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.s.Infow(msg, keysAndValues...)
+}
+
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.s.Warnw(msg, keysAndValues...)
+}
+
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.s.Errorw(msg, keysAndValues...)
+}